@@ -1,20 +1,23 @@
 package main
 
 import (
-	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/phungducminh/httpfromtcp/internal/fileserver"
 	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/middleware"
+	"github.com/phungducminh/httpfromtcp/internal/negotiate"
+	"github.com/phungducminh/httpfromtcp/internal/proxy"
 	"github.com/phungducminh/httpfromtcp/internal/request"
 	"github.com/phungducminh/httpfromtcp/internal/response"
 	"github.com/phungducminh/httpfromtcp/internal/server"
@@ -34,33 +37,35 @@ func respond200() string {
 </html>`
 }
 
-func respond400() string {
-	return `<html>
-  <head>
-    <title>400 Bad Request</title>
-  </head>
-  <body>
-    <h1>Bad Request</h1>
-    <p>Your request honestly kinda sucked.</p>
-  </body>
-</html>`
-}
-
-func respond500() string {
-	return `<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>`
+// errorPages renders the errors of the server in the same style as the 200
+// page, browsers get HTML while API clients can ask for JSON
+var errorPages = server.ErrorPages{
+	Default: "text/html",
+	Message: func(status response.StatusCode, err error) string {
+		switch {
+		case status == response.BadRequest:
+			return "Your request honestly kinda sucked."
+		case status >= 500:
+			return "Okay, you know what? This one is on me."
+		}
+		var he *server.HandlerError
+		if errors.As(err, &he) {
+			return he.Message
+		}
+		return err.Error()
+	},
 }
 
-
 func main() {
 	logLvl := flag.String("log-level", "INFO", "log level")
+	connectAllow := flag.String("connect-allow", "", "comma separated host[:port] destinations allowed for CONNECT, hosts can be names, IPs or CIDR prefixes, empty denies all")
+	connectDeny := flag.String("connect-deny", "", "comma separated host[:port] destinations denied for CONNECT")
+	upstreams := flag.String("upstreams", "", "comma separated upstream URLs served under /proxy/")
+	balance := flag.String("balance", "round-robin", "upstream balancing strategy: round-robin, least-connections or consistent-hash")
+	hashHeader := flag.String("hash-header", "", "request header hashed by consistent-hash, the client IP is used when empty")
+	healthPath := flag.String("health-path", "", "path requested on upstreams by active health checks, disabled when empty")
+	corsOrigins := flag.String("cors-origins", "", "comma separated origins allowed to call the server from browsers, as https://*.example.com for subdomains, CORS is disabled when empty")
+	assetsDir := flag.String("assets", "assets", "directory served under /assets/, /video serves vim.mp4 from it")
 
 	flag.Parse()
 
@@ -68,7 +73,7 @@ func main() {
 	case "DEBUG":
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	case "INFO":
-		slog.SetLogLoggerLevel(slog.LevelDebug)
+		slog.SetLogLoggerLevel(slog.LevelInfo)
 	case "WARN":
 		slog.SetLogLoggerLevel(slog.LevelWarn)
 	case "ERROR":
@@ -77,35 +82,127 @@ func main() {
 		panic("log level must be either DEBUG, INFO, WARN, ERROR")
 	}
 
-	var h server.Handler = func(w *response.Writer, req *request.Request) {
-		h := headers.NewHeaders()
-		h.Replace("Content-Type", "text/html")
+	tunnel := proxy.NewTunnel(proxy.TunnelConfig{
+		Allow: proxy.ParseRules(*connectAllow),
+		Deny:  proxy.ParseRules(*connectDeny),
+	})
+
+	var reverseProxy *proxy.ReverseProxy
+	if *upstreams != "" {
+		rp, err := proxy.NewReverseProxy(proxy.ReverseProxyConfig{
+			Upstreams:   strings.Split(*upstreams, ","),
+			StripPrefix: "/proxy",
+			Pool: proxy.PoolConfig{
+				Strategy:        proxy.Strategy(*balance),
+				HashHeader:      *hashHeader,
+				HealthCheckPath: *healthPath,
+			},
+		})
+		if err != nil {
+			log.Fatalf("Error configuring reverse proxy: %v", err)
+		}
+		defer rp.Close()
+		reverseProxy = rp
+	}
+
+	httpbin, err := proxy.NewReverseProxy(proxy.ReverseProxyConfig{
+		Upstreams:   []string{"https://httpbin.org"},
+		StripPrefix: "/httpbin",
+	})
+	if err != nil {
+		log.Fatalf("Error configuring httpbin proxy: %v", err)
+	}
+	defer httpbin.Close()
+
+	assets, err := fileserver.New(fileserver.Config{
+		Root:        *assetsDir,
+		StripPrefix: "/assets",
+		Listing:     true,
+	})
+	if err != nil {
+		slog.Warn("assets are not served", slog.Any("err", err))
+	} else {
+		defer assets.Close()
+	}
+
+	// the page is small enough to hold for its ETag, files and proxied
+	// responses are streamed
+	page := middleware.ETag(middleware.ETagConfig{})(func(w *response.Writer, req *request.Request) {
+		// browsers get the page, API clients can ask for JSON
+		mediaType, err := negotiate.Negotiate(req, []string{"text/html", "application/json"})
+		if err != nil {
+			w.WriteError(response.NotAcceptable, server.NewHandlerError(response.NotAcceptable, "Only HTML and JSON are served here."))
+			return
+		}
 		body := []byte(respond200())
-		status := 200
+		if mediaType == "application/json" {
+			body, _ = json.Marshal(struct {
+				Status  int    `json:"status"`
+				Title   string `json:"title"`
+				Message string `json:"message"`
+			}{200, "Success!", "Your request was an absolute banger."})
+		}
+		h := headers.NewHeaders()
+		h.Replace("Content-Type", mediaType)
+		h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
+		h.Replace("Vary", "Accept")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	})
+
+	var h server.Handler = func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "CONNECT" {
+			tunnel.Handle(w, req)
+			return
+		}
+		if reverseProxy != nil && strings.HasPrefix(req.RequestLine.RequestTarget, "/proxy/") {
+			reverseProxy.Handle(w, req)
+			return
+		}
+		if reverseProxy != nil && req.RequestLine.RequestTarget == "/admin/upstreams" {
+			reverseProxy.Pool().AdminHandler(w, req)
+			return
+		}
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/assets/") || req.RequestLine.RequestTarget == "/video" {
+			if assets == nil {
+				w.WriteError(response.NotFound, fileserver.ErrNotFound)
+			} else if req.RequestLine.RequestTarget == "/video" {
+				assets.ServeFile(w, req, "vim.mp4")
+			} else {
+				assets.Handle(w, req)
+			}
+			return
+		}
+
 		if strings.HasPrefix(req.RequestLine.RequestTarget, "/yourproblem") {
-			body = []byte(respond400())
-			status = 400
+			w.WriteError(response.BadRequest, errors.New("bad request"))
+			return
 		} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/myproblem") {
-			body = []byte(respond500())
-			status = 500
+			w.WriteError(response.InternalServerError, errors.New("internal server error"))
+			return
 		} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-			handleHttpBinRequest(req, w)
+			httpbin.Handle(w, req)
 			return
-		} else if req.RequestLine.RequestTarget == "/video" && req.RequestLine.Method == "GET" {
-			h.Replace("Content-Type", "video/mp4")
-			p, err := os.ReadFile("assets/vim.mp4")
-			if err != nil {
-				w.WriteInternalServerError(err, h)
-				return
-			}
-			body = p
 		}
-		h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-		w.WriteStatusLine(response.StatusCode(status))
-		w.WriteHeaders(h)
-		w.WriteBody(body)
+
+		page(w, req)
+	}
+	mws := []server.Middleware{
+		middleware.Compress(middleware.CompressConfig{}),
+		middleware.Decompress(middleware.DecompressConfig{}),
+	}
+	if *corsOrigins != "" {
+		// preflight requests are answered before anything else runs
+		mws = append([]server.Middleware{middleware.CORS(middleware.CORSConfig{
+			AllowedOrigins: strings.Split(*corsOrigins, ","),
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Authorization"},
+			MaxAge:         time.Hour,
+		})}, mws...)
 	}
-	server, err := server.Serve(port, h)
+	h = server.Chain(h, mws...)
+	server, err := server.Serve(port, h, server.WithErrorHandler(errorPages.Handle))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -117,55 +214,3 @@ func main() {
 	<-sigChan
 	log.Println("Server gracefully stopped")
 }
-
-func handleHttpBinRequest(req *request.Request, w *response.Writer){
-	h := headers.NewHeaders()
-	h.Replace("Content-Type", "text/html")
-	suffix := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	res, err := http.Get("https://httpbin.org/" + suffix)
-	if err != nil {
-		w.WriteInternalServerError(err, h)
-		return 
-	}
-
-	w.WriteStatusLine(response.OK)
-	h.Set("Transfer-Encoding", "chunked")
-	h.Set("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
-	h.Delete("Content-Length")
-	w.WriteHeaders(h)
-
-	hash256 := sha256.New()
-	size := 0
-	p := make([]byte, 32)
-	for {
-		eof := false
-		n, err := res.Body.Read(p)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				eof = true
-			} else {
-				slog.Error("failed to read request body", slog.Any("error", err))
-				// maybe return will close connection
-				return
-			}
-		}
-		if n == 0 {
-			break
-		}
-
-		slog.Debug("write chunk body", slog.String("data", string(p[:n])))
-		n, _ = hash256.Write(p[:n])
-		size += n
-		w.WriteChunkedBody(p[:n])
-		if eof {
-			break
-		}
-	}
-	slog.Debug("write chunk body done")
-	w.Write([]byte("0\r\n"))
-	trailers := headers.NewHeaders()
-	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", string(hash256.Sum(nil))))
-	trailers.Set("X-Content-Length", fmt.Sprintf("%d", size))
-	w.WriteTrailers(trailers)
-}
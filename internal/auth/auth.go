@@ -0,0 +1,166 @@
+// Package auth authenticates requests from their Authorization header, with
+// Basic credentials, bearer tokens or HMAC signatures. Other requests are
+// answered with 401 and a WWW-Authenticate challenge for the scheme
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+var (
+	ErrNoCredentials      = fmt.Errorf("auth: no credentials")
+	ErrMalformed          = fmt.Errorf("auth: malformed credentials")
+	ErrInvalidCredentials = fmt.Errorf("auth: invalid credentials")
+)
+
+// DefaultRealm is the realm of the challenges when none is configured
+const DefaultRealm = "restricted"
+
+// Principal is the authenticated client of a request
+type Principal struct {
+	// Scheme is the authentication scheme, Basic, Bearer or HMAC-SHA256
+	Scheme string
+	// Name is the user name, the subject of the bearer token or the key ID
+	// of the signature
+	Name string
+	// Claims is what the bearer token validator knows about the token
+	Claims any
+}
+
+type contextKey struct{}
+
+// FromRequest returns the principal of the request, nil when the handler
+// isn't wrapped by one of the middlewares
+func FromRequest(req *request.Request) *Principal {
+	p, _ := req.Context().Value(contextKey{}).(*Principal)
+	return p
+}
+
+func withPrincipal(req *request.Request, p *Principal) *request.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, p))
+}
+
+// ParseAuthorization returns the scheme of the Authorization header of the
+// request and its credentials, the token68 or auth-params following it.
+// Schemes are case-insensitive
+//
+// Authorization = auth-scheme [ 1*SP ( token68 / #auth-param ) ]
+func ParseAuthorization(req *request.Request) (scheme, credentials string, err error) {
+	v := strings.TrimSpace(req.Headers.Get("Authorization"))
+	if v == "" {
+		return "", "", ErrNoCredentials
+	}
+	scheme, credentials, _ = strings.Cut(v, " ")
+	if !isToken(scheme) {
+		return "", "", ErrMalformed
+	}
+	return scheme, strings.TrimSpace(credentials), nil
+}
+
+// ConstantTimeEqual reports whether a and b are equal in a time that depends
+// on neither, credential checkers use it so that response times don't
+// reveal how much of a secret was guessed
+func ConstantTimeEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// parseParams parses a list of auth-params, names are lower case
+//
+// auth-param = token BWS "=" BWS ( token / quoted-string )
+func parseParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		name, rest, ok := strings.Cut(s, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !isToken(name) {
+			return nil, ErrMalformed
+		}
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return nil, ErrMalformed
+			}
+			value, rest = b.String(), rest[i+1:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+			if !isToken(value) {
+				return nil, ErrMalformed
+			}
+		}
+		params[name] = value
+
+		rest = strings.TrimLeft(rest, " \t")
+		if rest != "" && rest[0] != ',' {
+			return nil, ErrMalformed
+		}
+		s = strings.TrimLeft(strings.TrimPrefix(rest, ","), " \t")
+	}
+	return params, nil
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// authHeader returns a WWW-Authenticate or Authorization value of the scheme,
+// params are pairs of names and values
+//
+// challenge = auth-scheme [ 1*SP ( token68 / #auth-param ) ]
+func authHeader(scheme string, params ...string) string {
+	var b strings.Builder
+	b.WriteString(scheme)
+	for i := 0; i+1 < len(params); i += 2 {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(params[i])
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(params[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// fail answers the request with the status, the challenge is sent in
+// WWW-Authenticate
+func fail(w *response.Writer, status response.StatusCode, challenge string, err error) {
+	w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+		h.Replace("WWW-Authenticate", challenge)
+	})
+	w.WriteError(status, server.NewHandlerError(status, err.Error()))
+}
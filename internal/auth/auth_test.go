@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// whoami answers with the name of the principal
+func whoami(w *response.Writer, req *request.Request) {
+	p := FromRequest(req)
+	body := p.Scheme + " " + p.Name
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestParseParams(t *testing.T) {
+	params, err := parseParams(`keyid="k1", Timestamp=17 ,headers="host  x-a", sig="a\"b\\c"`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"keyid": "k1", "timestamp": "17", "headers": "host  x-a", "sig": `a"b\c`}, params)
+
+	for _, s := range []string{`a`, `a="b`, `a=b c`, `a="b" c`, `=b`, `a=b;c`} {
+		_, err := parseParams(s)
+		assert.ErrorIs(t, err, ErrMalformed, s)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	h := Basic(BasicConfig{
+		Realm: `admin "area"`,
+		Check: Users(map[string]string{"alice": "s3cret:with:colons"}),
+	})(whoami)
+
+	basic := func(creds string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+	}
+	res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Authorization", basic("alice:s3cret:with:colons"))).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Equal(t, "Basic alice", string(res.Body))
+
+	tests := []struct {
+		description string
+		kv          []string
+	}{
+		{"missing", nil},
+		{"wrong password", []string{"Authorization", basic("alice:guess")}},
+		{"unknown user", []string{"Authorization", basic("bob:s3cret:with:colons")}},
+		{"no colon", []string{"Authorization", basic("alice")}},
+		{"not base64", []string{"Authorization", "Basic !!!"}},
+		{"other scheme", []string{"Authorization", "Bearer abc"}},
+	}
+	for _, tt := range tests {
+		res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, tt.kv...)).Result()
+		require.NoError(t, err, tt.description)
+		assert.Equal(t, response.Unauthorized, res.StatusCode(), tt.description)
+		assert.Equal(t, `Basic realm="admin \"area\"", charset="UTF-8"`, res.Headers.Get("WWW-Authenticate"), tt.description)
+	}
+
+	// no checker refuses everyone
+	h = Basic(BasicConfig{})(whoami)
+	res, err = httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Authorization", basic("alice:x"))).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.Unauthorized, res.StatusCode())
+	assert.Equal(t, `Basic realm="restricted", charset="UTF-8"`, res.Headers.Get("WWW-Authenticate"))
+}
+
+func TestBearer(t *testing.T) {
+	// the validator shares its principals between requests
+	svc := &Principal{Name: "svc", Claims: map[string]string{"scope": "read"}}
+	h := Bearer(BearerConfig{
+		Realm: "api",
+		Validate: func(token string) (*Principal, error) {
+			switch token {
+			case "mF_9.B5f-4.1JqM":
+				return svc, nil
+			case "revoked":
+				return nil, ErrInvalidCredentials
+			}
+			return nil, errors.New("db: connection refused")
+		},
+	})
+	var claims any
+	handler := h(func(w *response.Writer, req *request.Request) {
+		claims = FromRequest(req).Claims
+		whoami(w, req)
+	})
+
+	res, err := httptest.Record(handler, httptest.NewRequest("GET", "/", nil, "Authorization", "bearer mF_9.B5f-4.1JqM")).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Equal(t, "Bearer svc", string(res.Body))
+	assert.Equal(t, map[string]string{"scope": "read"}, claims)
+	assert.Empty(t, svc.Scheme)
+
+	tests := []struct {
+		description string
+		kv          []string
+		status      response.StatusCode
+		challenge   string
+	}{
+		{"missing", nil, response.Unauthorized, `Bearer realm="api"`},
+		{"other scheme", []string{"Authorization", "Basic YTpi"}, response.Unauthorized, `Bearer realm="api"`},
+		{"empty", []string{"Authorization", "Bearer"}, response.BadRequest, `Bearer realm="api", error="invalid_request"`},
+		{"not token68", []string{"Authorization", "Bearer a b"}, response.BadRequest, `Bearer realm="api", error="invalid_request"`},
+		{"revoked", []string{"Authorization", "Bearer revoked"}, response.Unauthorized, `Bearer realm="api", error="invalid_token", error_description="auth: invalid credentials"`},
+		{"validator failure", []string{"Authorization", "Bearer other"}, response.Unauthorized, `Bearer realm="api", error="invalid_token", error_description="invalid token"`},
+	}
+	for _, tt := range tests {
+		res, err := httptest.Record(handler, httptest.NewRequest("GET", "/", nil, tt.kv...)).Result()
+		require.NoError(t, err, tt.description)
+		assert.Equal(t, tt.status, res.StatusCode(), tt.description)
+		assert.Equal(t, tt.challenge, res.Headers.Get("WWW-Authenticate"), tt.description)
+		assert.NotContains(t, string(res.Body), "db:", tt.description)
+	}
+}
+
+func TestFromRequestUnauthenticated(t *testing.T) {
+	assert.Nil(t, FromRequest(httptest.NewRequest("GET", "/", nil)))
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+// BasicConfig configures Basic
+type BasicConfig struct {
+	// Realm is sent in the challenge, DefaultRealm when empty
+	Realm string
+	// Check reports whether the password of the user is right, every request
+	// is refused when nil. It should take as long for any wrong password,
+	// and for unknown users, as Users and ConstantTimeEqual do
+	Check func(user, password string) bool
+}
+
+// Basic authenticates requests with the Basic scheme of RFC 7617, the user
+// name is the Name of the principal
+func Basic(cfg BasicConfig) server.Middleware {
+	if cfg.Realm == "" {
+		cfg.Realm = DefaultRealm
+	}
+	c := authHeader("Basic", "realm", cfg.Realm, "charset", "UTF-8")
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			user, password, err := ParseBasic(req)
+			if err == nil && (cfg.Check == nil || !cfg.Check(user, password)) {
+				err = ErrInvalidCredentials
+			}
+			if err != nil {
+				fail(w, response.Unauthorized, c, err)
+				return
+			}
+			next(w, withPrincipal(req, &Principal{Scheme: "Basic", Name: user}))
+		}
+	}
+}
+
+// ParseBasic returns the user name and password of the Basic Authorization
+// header of the request
+//
+// credentials = "Basic" 1*SP base64( user-id ":" password )
+func ParseBasic(req *request.Request) (user, password string, err error) {
+	scheme, credentials, err := ParseAuthorization(req)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.EqualFold(scheme, "Basic") {
+		return "", "", ErrNoCredentials
+	}
+	p, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", "", ErrMalformed
+	}
+	user, password, ok := strings.Cut(string(p), ":")
+	if !ok {
+		return "", "", ErrMalformed
+	}
+	return user, password, nil
+}
+
+// Users returns a Check accepting the passwords of the map of user names.
+// Passwords are compared in constant time, unknown users included
+func Users(users map[string]string) func(user, password string) bool {
+	hashes := make(map[string][sha256.Size]byte, len(users))
+	for user, password := range users {
+		hashes[user] = sha256.Sum256([]byte(password))
+	}
+	return func(user, password string) bool {
+		want, ok := hashes[user]
+		got := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(want[:], got[:]) == 1 && ok
+	}
+}
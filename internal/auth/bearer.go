@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+// invalidTokenDescription describes the tokens refused by the validator
+const invalidTokenDescription = "invalid token"
+
+// BearerConfig configures Bearer
+type BearerConfig struct {
+	// Realm is sent in the challenge, DefaultRealm when empty
+	Realm string
+	// Validate returns the principal the token stands for, or an error when
+	// the token is unknown, expired or revoked. The principal isn't modified,
+	// it can be shared between requests. Only the errors of this package are
+	// described to the client, others as "invalid token". Every request is
+	// refused when nil
+	Validate func(token string) (*Principal, error)
+}
+
+// Bearer authenticates requests with the bearer tokens of RFC 6750. Requests
+// without a token get a bare challenge, malformed ones 400 with
+// error="invalid_request" and rejected tokens 401 with error="invalid_token"
+func Bearer(cfg BearerConfig) server.Middleware {
+	if cfg.Realm == "" {
+		cfg.Realm = DefaultRealm
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			token, err := ParseBearer(req)
+			switch {
+			case errors.Is(err, ErrNoCredentials):
+				fail(w, response.Unauthorized, authHeader("Bearer", "realm", cfg.Realm), err)
+				return
+			case err != nil:
+				fail(w, response.BadRequest, authHeader("Bearer", "realm", cfg.Realm, "error", "invalid_request"), err)
+				return
+			}
+
+			var p *Principal
+			if cfg.Validate == nil {
+				err = ErrInvalidCredentials
+			} else if p, err = cfg.Validate(token); err == nil && p == nil {
+				err = ErrInvalidCredentials
+			}
+			if err != nil {
+				// the validator's errors may tell about its internals
+				if !errors.Is(err, ErrInvalidCredentials) && !errors.Is(err, ErrMalformed) {
+					err = errors.New(invalidTokenDescription)
+				}
+				fail(w, response.Unauthorized, authHeader("Bearer", "realm", cfg.Realm, "error", "invalid_token", "error_description", err.Error()), err)
+				return
+			}
+			cp := *p
+			cp.Scheme = "Bearer"
+			next(w, withPrincipal(req, &cp))
+		}
+	}
+}
+
+// ParseBearer returns the token of the Bearer Authorization header of the
+// request
+//
+// credentials = "Bearer" 1*SP token68
+func ParseBearer(req *request.Request) (string, error) {
+	scheme, token, err := ParseAuthorization(req)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrNoCredentials
+	}
+	if !isToken68(token) {
+		return "", ErrMalformed
+	}
+	return token, nil
+}
+
+// isToken68 reports whether s is a token68
+//
+// token68 = 1*( ALPHA / DIGIT / "-" / "." / "_" / "~" / "+" / "/" ) *"="
+func isToken68(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~+/", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
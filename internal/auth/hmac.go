@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+var (
+	ErrInvalidSignature = fmt.Errorf("auth: invalid signature")
+	ErrStaleSignature   = fmt.Errorf("auth: signature timestamp outside of the window")
+	ErrUnsignedHeader   = fmt.Errorf("auth: required header not signed")
+)
+
+// HMACScheme is the authentication scheme of signed requests
+const HMACScheme = "HMAC-SHA256"
+
+// DefaultHMACWindow is how far the timestamp of a signature may be from the
+// clock of the server
+const DefaultHMACWindow = 5 * time.Minute
+
+// HMACConfig configures HMAC
+type HMACConfig struct {
+	// Realm is sent in the challenge, DefaultRealm when empty
+	Realm string
+	// Key returns the secret of the key ID, ok is false for unknown IDs.
+	// Every request is refused when nil
+	Key func(keyID string) (key []byte, ok bool)
+	// Headers must be signed, besides the method, target, timestamp and body
+	// which always are. Host by default
+	Headers []string
+	// Window bounds the age of signatures, DefaultHMACWindow when 0. A
+	// signature can be replayed within it, requests which must not be run
+	// twice need an idempotency key among the signed headers
+	Window time.Duration
+
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// HMAC authenticates requests signed by Sign with a shared secret. The key
+// ID is the Name of the principal. Requests whose signature is missing,
+// wrong, misses a required header or falls outside of the window are
+// answered with 401
+//
+// credentials = "HMAC-SHA256" 1*SP keyid=.., timestamp=.., headers=.., signature=..
+func HMAC(cfg HMACConfig) server.Middleware {
+	if cfg.Realm == "" {
+		cfg.Realm = DefaultRealm
+	}
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = []string{"Host"}
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultHMACWindow
+	}
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+	required := make([]string, len(cfg.Headers))
+	for i, name := range cfg.Headers {
+		required[i] = strings.ToLower(name)
+	}
+	c := authHeader(HMACScheme, "realm", cfg.Realm, "headers", strings.Join(required, " "))
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			keyID, err := verify(req, cfg, required)
+			if err != nil {
+				fail(w, response.Unauthorized, c, err)
+				return
+			}
+			next(w, withPrincipal(req, &Principal{Scheme: HMACScheme, Name: keyID}))
+		}
+	}
+}
+
+func verify(req *request.Request, cfg HMACConfig, required []string) (string, error) {
+	scheme, credentials, err := ParseAuthorization(req)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(scheme, HMACScheme) {
+		return "", ErrNoCredentials
+	}
+	params, err := parseParams(credentials)
+	if err != nil {
+		return "", err
+	}
+	keyID, ts, signature := params["keyid"], params["timestamp"], params["signature"]
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if keyID == "" || err != nil || signature == "" {
+		return "", ErrMalformed
+	}
+	signed := strings.Fields(params["headers"])
+	for _, name := range required {
+		if !slices.Contains(signed, name) {
+			return "", ErrUnsignedHeader
+		}
+	}
+	if d := cfg.now().Sub(time.Unix(unix, 0)); d > cfg.Window || d < -cfg.Window {
+		return "", ErrStaleSignature
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", ErrMalformed
+	}
+	var key []byte
+	ok := false
+	if cfg.Key != nil {
+		key, ok = cfg.Key(keyID)
+	}
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	if !hmac.Equal(mac, sign(req, key, ts, signed)) {
+		return "", ErrInvalidSignature
+	}
+	return keyID, nil
+}
+
+// Sign signs the request at t with the key, it sets the Authorization header
+// HMAC verifies. The names of the headers to sign are case-insensitive, the
+// method, target, timestamp and a SHA-256 digest of the body are always
+// signed. Headers mustn't change once signed
+func Sign(req *request.Request, keyID string, key []byte, signedHeaders []string, t time.Time) {
+	names := make([]string, len(signedHeaders))
+	for i, name := range signedHeaders {
+		names[i] = strings.ToLower(name)
+	}
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := sign(req, key, ts, names)
+	req.Headers.Replace("Authorization", authHeader(HMACScheme,
+		"keyid", keyID,
+		"timestamp", ts,
+		"headers", strings.Join(names, " "),
+		"signature", base64.StdEncoding.EncodeToString(mac),
+	))
+}
+
+// sign returns the HMAC-SHA256 of the signing string of the request, one
+// line per element
+//
+//	METHOD
+//	request-target
+//	timestamp
+//	name:value, per signed header in order, lower case names
+//	hex(SHA-256(body))
+func sign(req *request.Request, key []byte, ts string, names []string) []byte {
+	m := hmac.New(sha256.New, key)
+	fmt.Fprintf(m, "%s\n%s\n%s\n", req.RequestLine.Method, req.RequestLine.RequestTarget, ts)
+	for _, name := range names {
+		fmt.Fprintf(m, "%s:%s\n", name, strings.TrimSpace(strings.Join(req.Headers.Values(name), ", ")))
+	}
+	digest := sha256.Sum256(req.Body)
+	m.Write([]byte(hex.EncodeToString(digest[:])))
+	return m.Sum(nil)
+}
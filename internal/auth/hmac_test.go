@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	cfg := HMACConfig{
+		Key: func(keyID string) ([]byte, bool) {
+			return key, keyID == "k1"
+		},
+		Headers: []string{"Host", "Content-Type"},
+		now:     func() time.Time { return now },
+	}
+	h := HMAC(cfg)(whoami)
+
+	newRequest := func() *request.Request {
+		return httptest.NewRequest("POST", "/orders?id=1", []byte(`{"qty":1}`), "Content-Type", "application/json", "Idempotency-Key", "abc")
+	}
+	record := func(req *request.Request) *httptest.Result {
+		t.Helper()
+		res, err := httptest.Record(h, req).Result()
+		require.NoError(t, err)
+		return res
+	}
+
+	req := newRequest()
+	Sign(req, "k1", key, []string{"Host", "content-type", "Idempotency-Key"}, now.Add(-time.Minute))
+	res := record(req)
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Equal(t, "HMAC-SHA256 k1", string(res.Body))
+
+	tests := []struct {
+		description string
+		change      func(req *request.Request)
+	}{
+		{"missing", func(req *request.Request) { req.Headers.Delete("Authorization") }},
+		{"body", func(req *request.Request) { req.Body = []byte(`{"qty":9}`) }},
+		{"method", func(req *request.Request) { req.RequestLine.Method = "PUT" }},
+		{"target", func(req *request.Request) { req.RequestLine.RequestTarget = "/orders?id=2" }},
+		{"signed header", func(req *request.Request) { req.Headers.Replace("Idempotency-Key", "abd") }},
+		{"required header unsigned", func(req *request.Request) {
+			Sign(req, "k1", key, []string{"Host"}, now)
+		}},
+		{"stale", func(req *request.Request) {
+			Sign(req, "k1", key, []string{"Host", "Content-Type"}, now.Add(-6*time.Minute))
+		}},
+		{"future", func(req *request.Request) {
+			Sign(req, "k1", key, []string{"Host", "Content-Type"}, now.Add(6*time.Minute))
+		}},
+		{"unknown key", func(req *request.Request) {
+			Sign(req, "k2", key, []string{"Host", "Content-Type"}, now)
+		}},
+		{"wrong key", func(req *request.Request) {
+			Sign(req, "k1", []byte("another key"), []string{"Host", "Content-Type"}, now)
+		}},
+		{"malformed", func(req *request.Request) {
+			req.Headers.Replace("Authorization", `HMAC-SHA256 keyid="k1", timestamp=soon`)
+		}},
+	}
+	for _, tt := range tests {
+		req := newRequest()
+		Sign(req, "k1", key, []string{"Host", "Content-Type", "Idempotency-Key"}, now)
+		tt.change(req)
+		res := record(req)
+		assert.Equal(t, response.Unauthorized, res.StatusCode(), tt.description)
+		assert.Equal(t, `HMAC-SHA256 realm="restricted", headers="host content-type"`, res.Headers.Get("WWW-Authenticate"), tt.description)
+	}
+}
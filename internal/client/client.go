@@ -0,0 +1,394 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+var (
+	ErrUnsupportedScheme = fmt.Errorf("client: unsupported scheme")
+	ErrInvalidTarget     = fmt.Errorf("client: request target must be an absolute http or https URL")
+)
+
+type Config struct {
+	// Dial connects to the server, net.Dialer is used when nil
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig is used for https requests, its ServerName defaults to the
+	// host of the request
+	TLSConfig *tls.Config
+
+	// MaxIdleConnsPerHost bounds the keep-alive connections kept per host
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes keep-alive connections unused for that long
+	IdleConnTimeout time.Duration
+}
+
+// Client sends requests over HTTP/1.1 and keeps connections alive between
+// requests to the same host. It is safe for concurrent use
+type Client struct {
+	cfg Config
+
+	mu   sync.Mutex
+	idle map[string][]*conn
+}
+
+// conn is a connection to a host which can serve one request at a time
+type conn struct {
+	key    string
+	nc     net.Conn
+	br     *bufio.Reader
+	idleAt time.Time
+}
+
+func NewClient(cfg Config) *Client {
+	if cfg.Dial == nil {
+		cfg.Dial = (&net.Dialer{}).DialContext
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	return &Client{
+		cfg:  cfg,
+		idle: map[string][]*conn{},
+	}
+}
+
+// NewRequest creates a request to an absolute http or https URL, the request
+// target keeps the absolute form which Do relies on to find the server
+func NewRequest(method, rawURL string, body []byte) (*request.Request, error) {
+	u, err := parseTarget(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Replace("Host", u.Host)
+	if len(body) > 0 {
+		h.Replace("Content-Length", strconv.Itoa(len(body)))
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: u.String(),
+			Method:        method,
+		},
+		Headers: h,
+		Body:    body,
+	}, nil
+}
+
+// Get sends a GET request to the URL
+func (c *Client) Get(ctx context.Context, rawURL string) (*response.Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends the request and returns the response once its head has been
+// received. The request target must be an absolute URL, see NewRequest.
+//
+// The response body is streamed from the connection, the caller must read it
+// until io.EOF or close it. The connection goes back to the pool only when the
+// body has been read entirely. The context bounds the whole exchange including
+// reading the body
+func (c *Client) Do(ctx context.Context, req *request.Request) (*response.Response, error) {
+	u, err := parseTarget(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, err
+	}
+	key := u.Scheme + "://" + hostPort(u)
+
+	for {
+		cn, reused, err := c.getConn(ctx, u, key)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.roundTrip(ctx, cn, u, req)
+		if err == nil {
+			return res, nil
+		}
+		cn.nc.Close()
+
+		// a kept alive connection may have been closed by the server while
+		// idle, the request never reached it so it is retried on a new one
+		if reused && isIdempotent(req.RequestLine.Method) && ctx.Err() == nil && errors.Is(err, errConnReset) {
+			continue
+		}
+		return nil, contextError(ctx, err)
+	}
+}
+
+// contextError reports the context error for failures caused by the context,
+// the connection deadline may fire slightly before the context is done
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var ne net.Error
+	if _, ok := ctx.Deadline(); ok && errors.As(err, &ne) && ne.Timeout() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+// CloseIdleConnections closes the connections kept alive in the pool
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = map[string][]*conn{}
+	c.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, cn := range conns {
+			cn.nc.Close()
+		}
+	}
+}
+
+// errConnReset marks failures happening before any byte of the response was
+// received
+var errConnReset = fmt.Errorf("client: connection closed before the response")
+
+func (c *Client) roundTrip(ctx context.Context, cn *conn, u *url.URL, req *request.Request) (*response.Response, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.nc.SetDeadline(deadline)
+	} else {
+		cn.nc.SetDeadline(time.Time{})
+	}
+	// interrupt blocked reads and writes once the context is done
+	stop := context.AfterFunc(ctx, func() {
+		cn.nc.SetDeadline(time.Unix(1, 0))
+	})
+
+	if err := writeRequest(cn.nc, u, req); err != nil {
+		stop()
+		return nil, fmt.Errorf("%w: %w", errConnReset, err)
+	}
+
+	if _, err := cn.br.Peek(1); err != nil {
+		stop()
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || isConnReset(err) {
+			return nil, fmt.Errorf("%w: %w", errConnReset, err)
+		}
+		return nil, err
+	}
+
+	method := req.RequestLine.Method
+	for {
+		res, err := response.ResponseFromReader(cn.br, method)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		code := res.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != response.SwitchingProtocols {
+			// interim responses are followed by the final one
+			continue
+		}
+
+		b := &body{
+			r:     res.Body,
+			c:     c,
+			cn:    cn,
+			close: res.Close || code == response.SwitchingProtocols,
+			stop:  stop,
+		}
+		if !response.BodyAllowed(method, code) || res.ContentLength == 0 {
+			// nothing left to read, the connection is released right away
+			b.release(!b.close)
+		}
+		res.Body = b
+		return res, nil
+	}
+}
+
+func (c *Client) getConn(ctx context.Context, u *url.URL, key string) (*conn, bool, error) {
+	if cn := c.getIdle(key); cn != nil {
+		return cn, true, nil
+	}
+
+	nc, err := c.cfg.Dial(ctx, "tcp", hostPort(u))
+	if err != nil {
+		return nil, false, err
+	}
+	if u.Scheme == "https" {
+		cfg := &tls.Config{}
+		if c.cfg.TLSConfig != nil {
+			cfg = c.cfg.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(nc, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, false, err
+		}
+		nc = tlsConn
+	}
+	return &conn{key: key, nc: nc, br: bufio.NewReader(nc)}, false, nil
+}
+
+func (c *Client) getIdle(key string) *conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		// the most recently used connection is the least likely to be closed
+		cn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(cn.idleAt) < c.cfg.IdleConnTimeout {
+			c.idle[key] = conns
+			return cn
+		}
+		cn.nc.Close()
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+func (c *Client) putIdle(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[cn.key]) >= c.cfg.MaxIdleConnsPerHost {
+		cn.nc.Close()
+		return
+	}
+	cn.idleAt = time.Now()
+	c.idle[cn.key] = append(c.idle[cn.key], cn)
+}
+
+// body releases the connection once the response body is consumed
+type body struct {
+	r     io.ReadCloser
+	c     *Client
+	cn    *conn
+	close bool
+	stop  func() bool
+
+	mu   sync.Mutex
+	done bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return 0, io.EOF
+	}
+
+	n, err := b.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.release(!b.close)
+	} else if err != nil {
+		b.release(false)
+	}
+	return n, err
+}
+
+// Close discards the connection unless the body has been read entirely
+func (b *body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done {
+		b.release(false)
+	}
+	return nil
+}
+
+func (b *body) release(reuse bool) {
+	b.done = true
+	// the connection can't be reused when the context has already fired
+	if !b.stop() {
+		reuse = false
+	}
+	if reuse {
+		b.c.putIdle(b.cn)
+	} else {
+		b.cn.nc.Close()
+	}
+}
+
+// writeRequest sends the request with an origin-form target
+func writeRequest(w io.Writer, u *url.URL, req *request.Request) error {
+	h := headers.NewHeaders()
+	if req.Headers != nil {
+		h = req.Headers.Clone()
+	}
+	if h.Get("Host") == "" {
+		h.Replace("Host", u.Host)
+	}
+
+	out := &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: u.RequestURI(),
+			Method:        req.RequestLine.Method,
+		},
+		Headers: h,
+		Body:    req.Body,
+	}
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func parseTarget(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, ErrInvalidTarget
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedScheme, u.Scheme)
+	}
+	return u, nil
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func isConnReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, h server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, h)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	return "http://127.0.0.1:" + port
+}
+
+// startKeepAliveServer serves requests on each connection until the client
+// closes it, and counts the accepted connections
+func startKeepAliveServer(t *testing.T, accepted *atomic.Int64) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					req, err := request.RequestFromReader(conn)
+					if err != nil {
+						return
+					}
+					body := req.RequestLine.RequestTarget
+					fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+				}
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func readBody(t *testing.T, res *response.Response) string {
+	t.Helper()
+	p, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(p)
+}
+
+func TestClientDo(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := fmt.Sprintf("%s %s host=%s body=%s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Headers.Get("Host"), req.Body)
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("X-Test", "yes")
+		w.WriteStatusLine(response.Created)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+	c := NewClient(Config{})
+	defer c.CloseIdleConnections()
+
+	req, err := NewRequest("POST", base+"/users?id=1", []byte("hello"))
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, response.Created, res.StatusLine.StatusCode)
+	assert.Equal(t, "yes", res.Headers.Get("X-Test"))
+	assert.Equal(t, fmt.Sprintf("POST /users?id=1 host=%s body=hello", base[len("http://"):]), readBody(t, res))
+}
+
+func TestClientCookies(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := strings.Join(req.Headers.Values("Cookie"), "|")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	c := NewClient(Config{})
+	defer c.CloseIdleConnections()
+
+	req, err := NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Add("Cookie", "a=1")
+	req.Headers.Add("Cookie", "b=2")
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "a=1|b=2", readBody(t, res))
+}
+
+func TestClientChunkedResponse(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.Write([]byte("0\r\n"))
+		trailers := headers.NewHeaders()
+		trailers.Replace("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	})
+	c := NewClient(Config{})
+	defer c.CloseIdleConnections()
+
+	res, err := c.Get(context.Background(), base+"/stream")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", readBody(t, res))
+	assert.Equal(t, "abc", res.Trailers.Get("X-Checksum"))
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	var accepted atomic.Int64
+	base := startKeepAliveServer(t, &accepted)
+	c := NewClient(Config{})
+	defer c.CloseIdleConnections()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		res, err := c.Get(context.Background(), base+path)
+		require.NoError(t, err)
+		assert.Equal(t, path, readBody(t, res))
+	}
+	assert.Equal(t, int64(1), accepted.Load())
+
+	// a body closed before its end can't leave the connection in the pool
+	res, err := c.Get(context.Background(), base+"/d")
+	require.NoError(t, err)
+	res.Body.Close()
+	res, err = c.Get(context.Background(), base+"/e")
+	require.NoError(t, err)
+	assert.Equal(t, "/e", readBody(t, res))
+	assert.Equal(t, int64(2), accepted.Load())
+}
+
+func TestClientRetriesClosedIdleConnection(t *testing.T) {
+	// server.Server closes the connection after every response without
+	// announcing it, the pooled connection is stale on the next request
+	var requests atomic.Int64
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		requests.Add(1)
+		h := headers.NewHeaders()
+		h.Replace("Content-Length", "2")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	})
+	c := NewClient(Config{})
+	defer c.CloseIdleConnections()
+
+	for range 3 {
+		res, err := c.Get(context.Background(), base+"/")
+		require.NoError(t, err)
+		assert.Equal(t, "ok", readBody(t, res))
+		// let the server close the connection
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(3), requests.Load())
+}
+
+func TestClientContextTimeout(t *testing.T) {
+	base := startServer(t, func(w *response.Writer, req *request.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	c := NewClient(Config{})
+	defer c.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Get(ctx, base+"/slow")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err = c.Get(ctx, base+"/slow")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClientInvalidTarget(t *testing.T) {
+	c := NewClient(Config{})
+	_, err := c.Get(context.Background(), "/relative")
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+	_, err = c.Get(context.Background(), "ftp://example.com/file")
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+}
@@ -0,0 +1,133 @@
+// Package conditional evaluates the preconditions of RFC 9110 section 13,
+// letting handlers answer 304 Not Modified to clients holding a fresh copy and
+// 412 Precondition Failed to updates based on a stale one
+package conditional
+
+import (
+	"strings"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+)
+
+// TimeFormat is the IMF-fixdate format of HTTP dates
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Result is the outcome of the evaluation of the preconditions
+type Result int
+
+const (
+	// Proceed means the request is processed as if it had no precondition
+	Proceed Result = iota
+	// NotModified means a GET or HEAD is answered with 304
+	NotModified
+	// PreconditionFailed means the request is answered with 412
+	PreconditionFailed
+)
+
+// StatusCode returns the status of the response to send instead of the normal
+// one, 0 for Proceed
+func (r Result) StatusCode() response.StatusCode {
+	switch r {
+	case NotModified:
+		return response.NotModified
+	case PreconditionFailed:
+		return response.PreconditionFailed
+	default:
+		return 0
+	}
+}
+
+// Evaluate evaluates the preconditions of the request against the current
+// state of the target resource, its entity tag and its last modification
+// time. Either validator may be empty. A resource with neither is taken as
+// missing, "*" doesn't match it.
+//
+// The order of RFC 9110 13.2.2 is followed: If-Match, or If-Unmodified-Since
+// without it, then If-None-Match, or If-Modified-Since without it for GET and
+// HEAD
+func Evaluate(req *request.Request, etag string, modTime time.Time) Result {
+	h := req.Headers
+	exists := etag != "" || !modTime.IsZero()
+	modTime = modTime.Truncate(time.Second)
+
+	if im := h.Get("If-Match"); im != "" {
+		if !exists || !MatchETag(im, etag, true) {
+			return PreconditionFailed
+		}
+	} else if ius := h.Get("If-Unmodified-Since"); ius != "" && !modTime.IsZero() {
+		if t, err := ParseHTTPDate(ius); err == nil && modTime.After(t) {
+			return PreconditionFailed
+		}
+	}
+
+	safe := req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD"
+	if inm := h.Get("If-None-Match"); inm != "" {
+		if exists && MatchETag(inm, etag, false) {
+			if safe {
+				return NotModified
+			}
+			return PreconditionFailed
+		}
+	} else if ims := h.Get("If-Modified-Since"); ims != "" && safe && !modTime.IsZero() {
+		if t, err := ParseHTTPDate(ims); err == nil && !modTime.After(t) {
+			return NotModified
+		}
+	}
+	return Proceed
+}
+
+// RangeApplies evaluates If-Range, the Range header of the request is ignored
+// when it returns false because the representation changed. Only a strong
+// entity tag or an exact modification date match
+func RangeApplies(req *request.Request, etag string, modTime time.Time) bool {
+	ifRange := req.Headers.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && MatchETag(ifRange, etag, true)
+	}
+	t, err := ParseHTTPDate(ifRange)
+	return err == nil && t.Equal(modTime.Truncate(time.Second))
+}
+
+// MatchETag reports whether the list of entity tags matches etag, with the
+// strong comparison, where weak tags never match, or the weak one
+//
+// If-None-Match = "*" / #entity-tag
+func MatchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if strong {
+			if tag == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseHTTPDate accepts IMF-fixdate and the obsolete formats recipients must
+// still understand
+func ParseHTTPDate(s string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,89 @@
+package conditional
+
+import (
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	before := modTime.Add(-time.Hour).Format(TimeFormat)
+	at := modTime.Format(TimeFormat)
+	etag := `"v1"`
+
+	tests := []struct {
+		description string
+		method      string
+		kv          []string
+		etag        string
+		modTime     time.Time
+		want        Result
+	}{
+		{"none", "GET", nil, etag, modTime, Proceed},
+		{"if-none-match", "GET", []string{"If-None-Match", `"v0", "v1"`}, etag, modTime, NotModified},
+		{"if-none-match weak", "HEAD", []string{"If-None-Match", `W/"v1"`}, etag, modTime, NotModified},
+		{"if-none-match stale", "GET", []string{"If-None-Match", `"v0"`}, etag, modTime, Proceed},
+		{"if-none-match unsafe", "PUT", []string{"If-None-Match", "*"}, etag, modTime, PreconditionFailed},
+		{"if-none-match missing", "PUT", []string{"If-None-Match", "*"}, "", time.Time{}, Proceed},
+		{"if-match", "PUT", []string{"If-Match", etag}, etag, modTime, Proceed},
+		{"if-match weak", "PUT", []string{"If-Match", `W/"v1"`}, etag, modTime, PreconditionFailed},
+		{"if-match missing", "PUT", []string{"If-Match", "*"}, "", time.Time{}, PreconditionFailed},
+		{"if-match before if-none-match", "GET", []string{"If-Match", `"v0"`, "If-None-Match", etag}, etag, modTime, PreconditionFailed},
+		{"if-modified-since", "GET", []string{"If-Modified-Since", at}, etag, modTime, NotModified},
+		{"modified since", "GET", []string{"If-Modified-Since", before}, etag, modTime, Proceed},
+		{"if-modified-since unsafe", "POST", []string{"If-Modified-Since", at}, etag, modTime, Proceed},
+		{"if-none-match over if-modified-since", "GET", []string{"If-None-Match", `"v0"`, "If-Modified-Since", at}, etag, modTime, Proceed},
+		{"if-unmodified-since", "PUT", []string{"If-Unmodified-Since", at}, etag, modTime, Proceed},
+		{"unmodified since failed", "PUT", []string{"If-Unmodified-Since", before}, etag, modTime, PreconditionFailed},
+		{"if-match over if-unmodified-since", "PUT", []string{"If-Match", etag, "If-Unmodified-Since", before}, etag, modTime, Proceed},
+		{"invalid date", "GET", []string{"If-Modified-Since", "yesterday"}, etag, modTime, Proceed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil, tt.kv...)
+		assert.Equal(t, tt.want, Evaluate(req, tt.etag, tt.modTime), tt.description)
+	}
+}
+
+func TestRangeApplies(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		description string
+		ifRange     string
+		etag        string
+		want        bool
+	}{
+		{"none", "", `"v1"`, true},
+		{"etag", `"v1"`, `"v1"`, true},
+		{"stale etag", `"v0"`, `"v1"`, false},
+		{"weak etag", `W/"v1"`, `W/"v1"`, false},
+		{"date", modTime.Format(TimeFormat), `"v1"`, true},
+		{"stale date", modTime.Add(-time.Second).Format(TimeFormat), `"v1"`, false},
+		{"invalid", "yesterday", `"v1"`, false},
+	}
+	for _, tt := range tests {
+		var kv []string
+		if tt.ifRange != "" {
+			kv = []string{"If-Range", tt.ifRange}
+		}
+		req := httptest.NewRequest("GET", "/", nil, kv...)
+		assert.Equal(t, tt.want, RangeApplies(req, tt.etag, modTime), tt.description)
+	}
+}
+
+func TestParseHTTPDate(t *testing.T) {
+	want := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+	for _, s := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseHTTPDate(s)
+		assert.NoError(t, err, s)
+		assert.True(t, want.Equal(got), s)
+	}
+	_, err := ParseHTTPDate("06/11/1994")
+	assert.Error(t, err)
+}
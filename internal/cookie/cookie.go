@@ -0,0 +1,268 @@
+// Package cookie implements the cookies of RFC 6265: parsing the Cookie header
+// of requests and writing Set-Cookie lines
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidName      = fmt.Errorf("cookie: invalid name")
+	ErrInvalidValue     = fmt.Errorf("cookie: invalid value")
+	ErrInvalidAttribute = fmt.Errorf("cookie: invalid attribute")
+	ErrMalformed        = fmt.Errorf("cookie: malformed set-cookie")
+)
+
+// TimeFormat is the format of the Expires attribute
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite string
+
+const (
+	SameSiteDefault SameSite = ""
+	SameSiteLax     SameSite = "Lax"
+	SameSiteStrict  SameSite = "Strict"
+	SameSiteNone    SameSite = "None"
+)
+
+// Cookie is a cookie sent by a client, which only carries Name and Value, or
+// set by a server with a Set-Cookie line
+type Cookie struct {
+	Name  string
+	Value string
+
+	// Expires is left out when zero
+	Expires time.Time
+	// MaxAge is in seconds, it is left out when 0 and a negative value asks
+	// the client to delete the cookie now, as Max-Age=0
+	MaxAge   int
+	Domain   string
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keeps the cookie in storage partitioned by top-level site
+	// (CHIPS), it requires Secure
+	Partitioned bool
+}
+
+// Valid reports why the cookie can't be written as a Set-Cookie line
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("%w: %q", ErrInvalidValue, c.Value)
+	}
+	if c.Domain != "" && !validDomain(c.Domain) {
+		return fmt.Errorf("%w: domain %q", ErrInvalidAttribute, c.Domain)
+	}
+	if !validPath(c.Path) {
+		return fmt.Errorf("%w: path %q", ErrInvalidAttribute, c.Path)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("%w: expires %v", ErrInvalidAttribute, c.Expires)
+	}
+	switch c.SameSite {
+	case SameSiteDefault, SameSiteLax, SameSiteStrict:
+	case SameSiteNone:
+		if !c.Secure {
+			return fmt.Errorf("%w: SameSite=None requires Secure", ErrInvalidAttribute)
+		}
+	default:
+		return fmt.Errorf("%w: samesite %q", ErrInvalidAttribute, c.SameSite)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("%w: Partitioned requires Secure", ErrInvalidAttribute)
+	}
+	return nil
+}
+
+// String returns the Set-Cookie value of the cookie, it is only well formed
+// when Valid returns nil
+//
+// set-cookie-string = cookie-pair *( ";" SP cookie-av )
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	if strings.ContainsAny(c.Value, " ,") {
+		// allowed by browsers only when quoted
+		b.WriteString(`"` + c.Value + `"`)
+	} else {
+		b.WriteString(c.Value)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		// a leading dot is ignored by clients
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + string(c.SameSite))
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse returns the cookies of a Cookie header value, those named name or all
+// of them when name is empty. Malformed pairs are skipped
+//
+// cookie-string = cookie-pair *( ";" SP cookie-pair )
+func Parse(header, name string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(header, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !isToken(k) || name != "" && k != name {
+			continue
+		}
+		v, ok = unquote(v)
+		if !ok || !validValue(v) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: k, Value: v})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses a Set-Cookie value, unknown attributes are ignored as
+// clients do
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	k, v, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || !isToken(k) {
+		return nil, fmt.Errorf("%w: %q", ErrMalformed, line)
+	}
+	v, ok = unquote(v)
+	if !ok || !validValue(v) {
+		return nil, fmt.Errorf("%w: %q", ErrMalformed, line)
+	}
+
+	c := &Cookie{Name: k, Value: v}
+	for _, av := range parts[1:] {
+		attr, value, _ := strings.Cut(strings.TrimSpace(av), "=")
+		switch strings.ToLower(attr) {
+		case "path":
+			c.Path = value
+		case "domain":
+			c.Domain = strings.TrimPrefix(value, ".")
+		case "expires":
+			t, err := time.Parse(TimeFormat, value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: expires %q", ErrMalformed, value)
+			}
+			c.Expires = t
+		case "max-age":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: max-age %q", ErrMalformed, value)
+			}
+			c.MaxAge = n
+			if n <= 0 {
+				c.MaxAge = -1
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "partitioned":
+			c.Partitioned = true
+		case "samesite":
+			switch strings.ToLower(value) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		}
+	}
+	return c, nil
+}
+
+func unquote(v string) (string, bool) {
+	if len(v) > 0 && v[0] == '"' {
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return "", false
+		}
+		return v[1 : len(v)-1], true
+	}
+	return v, true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue reports whether the value is made of cookie-octets, space and
+// comma which are written quoted
+//
+// cookie-octet = %x21 / %x23-2B / %x2D-3A / %x3C-5B / %x5D-7E
+func validValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(d string) bool {
+	d = strings.TrimPrefix(d, ".")
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validPath rejects control characters and the ';' ending the attribute
+func validPath(p string) bool {
+	for i := 0; i < len(p); i++ {
+		if p[i] < 0x20 || p[i] >= 0x7f || p[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieString(t *testing.T) {
+	expires := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	tests := []struct {
+		cookie Cookie
+		expect string
+	}{
+		{Cookie{Name: "id", Value: "a3fWa"}, "id=a3fWa"},
+		{Cookie{Name: "empty"}, "empty="},
+		{Cookie{Name: "q", Value: "a b,c"}, `q="a b,c"`},
+		{
+			Cookie{Name: "id", Value: "1", Path: "/", Domain: ".example.com", Expires: expires, MaxAge: 3600, HttpOnly: true, Secure: true, SameSite: SameSiteLax},
+			"id=1; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=Lax",
+		},
+		{Cookie{Name: "id", MaxAge: -1}, "id=; Max-Age=0"},
+		{Cookie{Name: "__Host-id", Value: "1", Path: "/", Secure: true, SameSite: SameSiteNone, Partitioned: true}, "__Host-id=1; Path=/; Secure; SameSite=None; Partitioned"},
+	}
+	for _, tt := range tests {
+		require.NoError(t, tt.cookie.Valid(), tt.expect)
+		assert.Equal(t, tt.expect, tt.cookie.String())
+	}
+}
+
+func TestCookieValid(t *testing.T) {
+	tests := []struct {
+		cookie Cookie
+		err    error
+	}{
+		{Cookie{}, ErrInvalidName},
+		{Cookie{Name: "a b"}, ErrInvalidName},
+		{Cookie{Name: "a=b"}, ErrInvalidName},
+		{Cookie{Name: "a;"}, ErrInvalidName},
+		{Cookie{Name: "a", Value: "x;y"}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: `"x"`}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: "x\ny"}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: `x\y`}, ErrInvalidValue},
+		{Cookie{Name: "a", Value: "é"}, ErrInvalidValue},
+		{Cookie{Name: "a", Domain: "exa mple.com"}, ErrInvalidAttribute},
+		{Cookie{Name: "a", Domain: "-example.com"}, ErrInvalidAttribute},
+		{Cookie{Name: "a", Path: "/; Secure"}, ErrInvalidAttribute},
+		{Cookie{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)}, ErrInvalidAttribute},
+		{Cookie{Name: "a", SameSite: "lax"}, ErrInvalidAttribute},
+		{Cookie{Name: "a", SameSite: SameSiteNone}, ErrInvalidAttribute},
+		{Cookie{Name: "a", Partitioned: true}, ErrInvalidAttribute},
+	}
+	for _, tt := range tests {
+		assert.ErrorIs(t, tt.cookie.Valid(), tt.err, "%+v", tt.cookie)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`id=a3fWa; theme=light;  q="a b" ; bad name=1; noequals; x=y;z; empty=`, "")
+	var got []string
+	for _, c := range cookies {
+		got = append(got, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"id=a3fWa", "theme=light", "q=a b", "x=y", "empty="}, got)
+
+	cookies = Parse("a=1; b=2; a=3", "a")
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "1", cookies[0].Value)
+	assert.Equal(t, "3", cookies[1].Value)
+	assert.Empty(t, Parse(`a="1`, ""))
+}
+
+func TestParseSetCookie(t *testing.T) {
+	want := &Cookie{
+		Name: "id", Value: "1", Path: "/", Domain: "example.com",
+		Expires: time.Date(2030, time.January, 2, 2, 4, 5, 0, time.UTC), MaxAge: 3600,
+		HttpOnly: true, Secure: true, SameSite: SameSiteNone, Partitioned: true,
+	}
+	c, err := ParseSetCookie(want.String())
+	require.NoError(t, err)
+	assert.Equal(t, want, c)
+
+	c, err = ParseSetCookie("id=1; max-age=0; samesite=strict; Unknown=1")
+	require.NoError(t, err)
+	assert.Equal(t, -1, c.MaxAge)
+	assert.Equal(t, SameSiteStrict, c.SameSite)
+
+	for _, line := range []string{"", "novalue", "a b=1", "a=x;y=1; Expires=tomorrow", "a=1; Max-Age=soon", `a="1`} {
+		_, err := ParseSetCookie(line)
+		assert.ErrorIs(t, err, ErrMalformed, line)
+	}
+}
@@ -0,0 +1,347 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/phungducminh/httpfromtcp/internal/conditional"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+)
+
+// TimeFormat is the IMF-fixdate format of HTTP dates
+const TimeFormat = conditional.TimeFormat
+
+// sniffLen is the number of bytes looked at to detect a text file
+const sniffLen = 512
+
+var (
+	ErrNotFound    = fmt.Errorf("fileserver: not found")
+	ErrForbidden   = fmt.Errorf("fileserver: forbidden")
+	ErrInvalidPath = fmt.Errorf("fileserver: invalid path")
+)
+
+type Config struct {
+	// Root is the directory files are served from, nothing outside of it is
+	// reachable, symbolic links included
+	Root string
+	// StripPrefix is removed from the request target before it is resolved
+	// under Root, targets it isn't a path prefix of are not found. A trailing
+	// slash is ignored
+	StripPrefix string
+	// Index is the file served for a directory, index.html by default
+	Index string
+	// Listing lists the entries of directories without an index
+	Listing bool
+}
+
+// FileServer serves the files of a directory to GET and HEAD requests. It
+// answers conditional requests with 304 and Range requests with 206, files are
+// streamed from disk
+type FileServer struct {
+	cfg  Config
+	root *os.Root
+}
+
+func New(cfg Config) (*FileServer, error) {
+	if cfg.Index == "" {
+		cfg.Index = "index.html"
+	}
+	cfg.StripPrefix = strings.TrimSuffix(cfg.StripPrefix, "/")
+	root, err := os.OpenRoot(cfg.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &FileServer{cfg: cfg, root: root}, nil
+}
+
+// Close releases the root directory
+func (s *FileServer) Close() error {
+	return s.root.Close()
+}
+
+func (s *FileServer) Handle(w *response.Writer, req *request.Request) {
+	if !allowedMethod(w, req) {
+		return
+	}
+
+	target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	target, ok := strings.CutPrefix(target, s.cfg.StripPrefix)
+	// the prefix ends at a segment boundary, /staticfoo isn't under /static
+	if !ok || target != "" && !strings.HasPrefix(target, "/") {
+		w.WriteError(response.NotFound, ErrNotFound)
+		return
+	}
+	name, err := url.PathUnescape(target)
+	if err != nil || strings.ContainsRune(name, 0) {
+		w.WriteError(response.BadRequest, ErrInvalidPath)
+		return
+	}
+
+	// a cleaned rooted path has no ".." left, os.Root rejects anything else
+	// escaping the root such as symbolic links
+	clean := path.Clean("/" + name)
+	fi, err := s.root.Stat(rootName(clean))
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	if !fi.IsDir() {
+		s.serveFile(w, req, clean)
+		return
+	}
+
+	if !strings.HasSuffix(target, "/") {
+		// relative links of the directory only resolve with a trailing slash
+		redirect(w, s.cfg.StripPrefix+target+"/")
+		return
+	}
+	index := path.Join(clean, s.cfg.Index)
+	if fi, err := s.root.Stat(rootName(index)); err == nil && !fi.IsDir() {
+		s.serveFile(w, req, index)
+		return
+	}
+	if !s.cfg.Listing {
+		w.WriteError(response.Forbidden, ErrForbidden)
+		return
+	}
+	s.serveListing(w, req, clean)
+}
+
+// ServeFile serves the named file of the root whatever the request target is
+func (s *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !allowedMethod(w, req) {
+		return
+	}
+	s.serveFile(w, req, path.Clean("/"+name))
+}
+
+func (s *FileServer) serveFile(w *response.Writer, req *request.Request, name string) {
+	f, err := s.root.Open(rootName(name))
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		w.WriteError(response.InternalServerError, err)
+		return
+	}
+	if fi.IsDir() {
+		w.WriteError(response.NotFound, ErrNotFound)
+		return
+	}
+
+	contentType, err := detectContentType(f, name)
+	if err != nil {
+		w.WriteError(response.InternalServerError, err)
+		return
+	}
+
+	size := fi.Size()
+	modTime := fi.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), size)
+
+	h := headers.NewHeaders()
+	h.Replace("Connection", "close")
+	h.Replace("ETag", etag)
+	h.Replace("Last-Modified", modTime.Format(TimeFormat))
+	h.Replace("Accept-Ranges", "bytes")
+
+	switch conditional.Evaluate(req, etag, modTime) {
+	case conditional.NotModified:
+		w.WriteStatusLine(response.NotModified)
+		w.WriteHeaders(h)
+		return
+	case conditional.PreconditionFailed:
+		h.Replace("Content-Length", "0")
+		w.WriteStatusLine(response.PreconditionFailed)
+		w.WriteHeaders(h)
+		return
+	}
+
+	var ranges []byteRange
+	if rh := req.Headers.Get("Range"); rh != "" && req.RequestLine.Method == "GET" &&
+		conditional.RangeApplies(req, etag, modTime) {
+		var satisfiable bool
+		ranges, satisfiable = parseRange(rh, size)
+		if !satisfiable {
+			h.Replace("Content-Range", fmt.Sprintf("bytes */%d", size))
+			h.Replace("Content-Length", "0")
+			w.WriteStatusLine(response.RangeNotSatisfiable)
+			w.WriteHeaders(h)
+			return
+		}
+	}
+
+	body := hasBody(req)
+	switch len(ranges) {
+	case 0:
+		h.Replace("Content-Type", contentType)
+		h.Replace("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		if body {
+			copyRange(w, f, byteRange{0, size})
+		}
+	case 1:
+		r := ranges[0]
+		h.Replace("Content-Type", contentType)
+		h.Replace("Content-Range", r.contentRange(size))
+		h.Replace("Content-Length", strconv.FormatInt(r.length, 10))
+		w.WriteStatusLine(response.PartialContent)
+		w.WriteHeaders(h)
+		if body {
+			copyRange(w, f, r)
+		}
+	default:
+		mp := newMultipartRanges(ranges, contentType, size)
+		h.Replace("Content-Type", "multipart/byteranges; boundary="+mp.boundary)
+		h.Replace("Content-Length", strconv.FormatInt(mp.length(), 10))
+		w.WriteStatusLine(response.PartialContent)
+		w.WriteHeaders(h)
+		if body {
+			mp.writeTo(w, f)
+		}
+	}
+}
+
+func (s *FileServer) serveListing(w *response.Writer, req *request.Request, dir string) {
+	f, err := s.root.Open(rootName(dir))
+	if err != nil {
+		writeOpenError(w, err)
+		return
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		w.WriteError(response.InternalServerError, err)
+		return
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	var b strings.Builder
+	title := html.EscapeString("Index of " + dir)
+	fmt.Fprintf(&b, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <ul>\n", title, title)
+	if dir != "/" {
+		b.WriteString("      <li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(name, ":") {
+			// a colon in the first segment would read as a scheme
+			href = "./" + href
+		}
+		fmt.Fprintf(&b, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	h := response.GetDefaultHeaders(b.Len())
+	h.Replace("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	if hasBody(req) {
+		w.WriteBody([]byte(b.String()))
+	}
+}
+
+func allowedMethod(w *response.Writer, req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD":
+		return true
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Replace("Allow", "GET, HEAD")
+	w.WriteStatusLine(response.MethodNotAllowed)
+	w.WriteHeaders(h)
+	return false
+}
+
+// hasBody reports whether the response carries a body, HEAD responses only
+// have the headers
+func hasBody(req *request.Request) bool {
+	return req.RequestLine.Method != "HEAD"
+}
+
+// rootName turns a cleaned rooted path into a name relative to the root
+func rootName(clean string) string {
+	if clean == "/" {
+		return "."
+	}
+	return clean[1:]
+}
+
+func redirect(w *response.Writer, location string) {
+	h := response.GetDefaultHeaders(0)
+	h.Replace("Location", location)
+	w.WriteStatusLine(response.MovedPermanently)
+	w.WriteHeaders(h)
+}
+
+func writeOpenError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		w.WriteError(response.NotFound, ErrNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		w.WriteError(response.Forbidden, ErrForbidden)
+	default:
+		// os.Root reports paths escaping the root, they don't exist for
+		// the client
+		slog.Debug("fileserver: failed to open", slog.Any("err", err))
+		w.WriteError(response.NotFound, ErrNotFound)
+	}
+}
+
+// detectContentType uses the extension of the file, or looks for text in its
+// first bytes when the extension is unknown
+func detectContentType(f *os.File, name string) (string, error) {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct, nil
+	}
+
+	p := make([]byte, sniffLen)
+	n, err := f.ReadAt(p, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	p = p[:n]
+	// a multi-byte rune may be cut at the end of the sample
+	for i := 0; i < utf8.UTFMax && len(p) > 0 && !utf8.Valid(p); i++ {
+		p = p[:len(p)-1]
+	}
+	if utf8.Valid(p) && !slices.Contains(p, 0) {
+		return "text/plain; charset=utf-8", nil
+	}
+	return "application/octet-stream", nil
+}
+
+// copyRange copies the range of the file to w. The reader is the file itself,
+// limited, so that a response.Writer on a TCP connection can use sendfile
+func copyRange(w io.Writer, f *os.File, r byteRange) error {
+	if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, io.LimitReader(f, r.length))
+	return err
+}
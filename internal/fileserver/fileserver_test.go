@@ -0,0 +1,229 @@
+package fileserver
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func newTestFileServer(t *testing.T, cfg Config) (*FileServer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte(content), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "noext"), []byte("plain text"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "binary"), []byte{0, 1, 2, 3}, 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "site", "index.html"), []byte("<p>home</p>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "a <b>.md"), []byte("# a"), 0o644))
+
+	outside := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	if cfg.Root == "" {
+		cfg.Root = dir
+	}
+	s, err := New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, dir
+}
+
+func serve(t *testing.T, s *FileServer, method, target string, kv ...string) *httptest.Result {
+	t.Helper()
+	rec := httptest.Record(s.Handle, httptest.NewRequest(method, target, nil, kv...))
+	res, err := rec.ResultFor(method)
+	require.NoError(t, err)
+	return res
+}
+
+func TestFileServer(t *testing.T) {
+	s, _ := newTestFileServer(t, Config{StripPrefix: "/static", Listing: true})
+
+	tests := []struct {
+		description  string
+		method       string
+		target       string
+		expectStatus response.StatusCode
+		expectType   string
+		expectBody   string
+	}{
+		{"file", "GET", "/static/file.txt", response.OK, "text/plain; charset=utf-8", content},
+		{"escaped path", "GET", "/static/file%2etxt?v=1", response.OK, "text/plain; charset=utf-8", content},
+		{"head", "HEAD", "/static/file.txt", response.OK, "text/plain; charset=utf-8", ""},
+		{"sniffed text", "GET", "/static/noext", response.OK, "text/plain; charset=utf-8", "plain text"},
+		{"sniffed binary", "GET", "/static/binary", response.OK, "application/octet-stream", "\x00\x01\x02\x03"},
+		{"index", "GET", "/static/site/", response.OK, "text/html; charset=utf-8", "<p>home</p>"},
+		{"missing", "GET", "/static/missing", response.NotFound, "text/plain", "fileserver: not found"},
+		{"traversal", "GET", "/static/../../etc/passwd", response.NotFound, "text/plain", "fileserver: not found"},
+		{"encoded traversal", "GET", "/static/%2e%2e/%2e%2e/etc/passwd", response.NotFound, "text/plain", "fileserver: not found"},
+		{"symlink escaping the root", "GET", "/static/link", response.NotFound, "text/plain", "fileserver: not found"},
+		{"nul byte", "GET", "/static/file.txt%00", response.BadRequest, "text/plain", "fileserver: invalid path"},
+		{"other prefix", "GET", "/other/file.txt", response.NotFound, "text/plain", "fileserver: not found"},
+		{"prefix of a segment", "GET", "/staticfile.txt", response.NotFound, "text/plain", "fileserver: not found"},
+		{"method", "POST", "/static/file.txt", response.MethodNotAllowed, "text/plain", ""},
+	}
+
+	for _, tt := range tests {
+		res := serve(t, s, tt.method, tt.target)
+		assert.Equal(t, tt.expectStatus, res.StatusCode(), tt.description)
+		assert.Equal(t, tt.expectType, res.Headers.Get("Content-Type"), tt.description)
+		assert.Equal(t, tt.expectBody, string(res.Body), tt.description)
+	}
+
+	res := serve(t, s, "GET", "/static/file.txt")
+	assert.Equal(t, "36", res.Headers.Get("Content-Length"))
+	assert.Equal(t, "bytes", res.Headers.Get("Accept-Ranges"))
+	assert.NotEmpty(t, res.Headers.Get("ETag"))
+	assert.NotEmpty(t, res.Headers.Get("Last-Modified"))
+
+	res = serve(t, s, "POST", "/static/file.txt")
+	assert.Equal(t, "GET, HEAD", res.Headers.Get("Allow"))
+}
+
+func TestFileServerDirectories(t *testing.T) {
+	s, _ := newTestFileServer(t, Config{Listing: true})
+
+	res := serve(t, s, "GET", "/docs")
+	assert.Equal(t, response.MovedPermanently, res.StatusCode())
+	assert.Equal(t, "/docs/", res.Headers.Get("Location"))
+
+	res = serve(t, s, "GET", "/docs/")
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Contains(t, string(res.Body), `<a href="../">../</a>`)
+	assert.Contains(t, string(res.Body), `<a href="a%20%3Cb%3E.md">a &lt;b&gt;.md</a>`)
+
+	s, _ = newTestFileServer(t, Config{})
+	res = serve(t, s, "GET", "/docs/")
+	assert.Equal(t, response.Forbidden, res.StatusCode())
+}
+
+func TestFileServerConditional(t *testing.T) {
+	s, dir := newTestFileServer(t, Config{})
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "file.txt"), modTime, modTime))
+
+	res := serve(t, s, "GET", "/file.txt")
+	etag := res.Headers.Get("ETag")
+	assert.Equal(t, "Wed, 01 May 2024 10:00:00 GMT", res.Headers.Get("Last-Modified"))
+
+	tests := []struct {
+		description  string
+		kv           []string
+		expectStatus response.StatusCode
+	}{
+		{"matching etag", []string{"If-None-Match", etag}, response.NotModified},
+		{"weak etag in a list", []string{"If-None-Match", `"other", W/` + etag}, response.NotModified},
+		{"any etag", []string{"If-None-Match", "*"}, response.NotModified},
+		{"other etag", []string{"If-None-Match", `"other"`}, response.OK},
+		{"not modified since", []string{"If-Modified-Since", "Wed, 01 May 2024 10:00:00 GMT"}, response.NotModified},
+		{"modified since", []string{"If-Modified-Since", "Wed, 01 May 2024 09:59:59 GMT"}, response.OK},
+		{"if-none-match takes precedence", []string{"If-None-Match", `"other"`, "If-Modified-Since", "Wed, 01 May 2024 10:00:00 GMT"}, response.OK},
+		{"malformed date", []string{"If-Modified-Since", "yesterday"}, response.OK},
+		{"if-match", []string{"If-Match", etag}, response.OK},
+		{"if-match failed", []string{"If-Match", `"other"`}, response.PreconditionFailed},
+		{"if-match weak", []string{"If-Match", "W/" + etag}, response.PreconditionFailed},
+		{"unmodified since", []string{"If-Unmodified-Since", "Wed, 01 May 2024 10:00:00 GMT"}, response.OK},
+		{"modified after", []string{"If-Unmodified-Since", "Wed, 01 May 2024 09:59:59 GMT"}, response.PreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		res := serve(t, s, "GET", "/file.txt", tt.kv...)
+		assert.Equal(t, tt.expectStatus, res.StatusCode(), tt.description)
+		if tt.expectStatus == response.NotModified {
+			assert.Empty(t, res.Body, tt.description)
+			assert.Equal(t, etag, res.Headers.Get("ETag"), tt.description)
+		}
+	}
+}
+
+func TestFileServerRange(t *testing.T) {
+	s, _ := newTestFileServer(t, Config{})
+	etag := serve(t, s, "GET", "/file.txt").Headers.Get("ETag")
+
+	tests := []struct {
+		description  string
+		kv           []string
+		expectStatus response.StatusCode
+		expectRange  string
+		expectBody   string
+	}{
+		{"first bytes", []string{"Range", "bytes=0-9"}, response.PartialContent, "bytes 0-9/36", "0123456789"},
+		{"open ended", []string{"Range", "bytes=30-"}, response.PartialContent, "bytes 30-35/36", "uvwxyz"},
+		{"suffix", []string{"Range", "bytes=-3"}, response.PartialContent, "bytes 33-35/36", "xyz"},
+		{"last pos past the end", []string{"Range", "bytes=34-100"}, response.PartialContent, "bytes 34-35/36", "yz"},
+		{"unsatisfiable", []string{"Range", "bytes=36-"}, response.RangeNotSatisfiable, "bytes */36", ""},
+		{"malformed", []string{"Range", "bytes=a-b"}, response.OK, "", content},
+		{"other unit", []string{"Range", "items=0-1"}, response.OK, "", content},
+		{"overlapping", []string{"Range", "bytes=0-35,0-35"}, response.OK, "", content},
+		{"if-range etag", []string{"Range", "bytes=0-1", "If-Range", etag}, response.PartialContent, "bytes 0-1/36", "01"},
+		{"if-range changed", []string{"Range", "bytes=0-1", "If-Range", `"old"`}, response.OK, "", content},
+		{"if-range weak", []string{"Range", "bytes=0-1", "If-Range", "W/" + etag}, response.OK, "", content},
+	}
+
+	for _, tt := range tests {
+		res := serve(t, s, "GET", "/file.txt", tt.kv...)
+		assert.Equal(t, tt.expectStatus, res.StatusCode(), tt.description)
+		assert.Equal(t, tt.expectRange, res.Headers.Get("Content-Range"), tt.description)
+		assert.Equal(t, tt.expectBody, string(res.Body), tt.description)
+	}
+
+	// a range is ignored by HEAD
+	res := serve(t, s, "HEAD", "/file.txt", "Range", "bytes=0-1")
+	assert.Equal(t, response.OK, res.StatusCode())
+}
+
+func TestFileServerMultipartRange(t *testing.T) {
+	s, _ := newTestFileServer(t, Config{})
+	res := serve(t, s, "GET", "/file.txt", "Range", "bytes=0-1, 10-12, -2")
+	require.Equal(t, response.PartialContent, res.StatusCode())
+	assert.Equal(t, res.Headers.Get("Content-Length"), strconv.Itoa(len(res.Body)))
+
+	mediaType, params, err := mime.ParseMediaType(res.Headers.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	mr := multipart.NewReader(strings.NewReader(string(res.Body)), params["boundary"])
+	expect := []struct{ contentRange, body string }{
+		{"bytes 0-1/36", "01"},
+		{"bytes 10-12/36", "abc"},
+		{"bytes 34-35/36", "yz"},
+	}
+	for _, e := range expect {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		assert.Equal(t, e.contentRange, part.Header.Get("Content-Range"))
+		p, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, e.body, string(p))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeFile(t *testing.T) {
+	s, _ := newTestFileServer(t, Config{})
+	rec := httptest.Record(func(w *response.Writer, req *request.Request) {
+		s.ServeFile(w, req, "file.txt")
+	}, httptest.NewRequest("GET", "/anything", nil, "Range", "bytes=-1"))
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.PartialContent, res.StatusCode())
+	assert.Equal(t, "z", string(res.Body))
+}
@@ -0,0 +1,153 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds the ranges of a request, more are ignored as an abuse
+const maxRanges = 100
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange returns the satisfiable ranges of the Range header value for a
+// representation of size bytes. A malformed header, or one asking for more
+// than the representation, returns no range and the whole representation is
+// served, satisfiable is false when none of the ranges is
+//
+// Range = ranges-specifier
+// ranges-specifier = range-unit "=" range-set
+// range-set = 1#range-spec
+// range-spec = int-range / suffix-range
+func parseRange(s string, size int64) (ranges []byteRange, satisfiable bool) {
+	unit, set, ok := strings.Cut(s, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, true
+	}
+
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, true
+	}
+	var total int64
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			// empty list elements are allowed
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, true
+		}
+
+		var r byteRange
+		if first == "" {
+			// suffix-range = "-" suffix-length
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 || !isDigits(last) {
+				return nil, true
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{start: size - n, length: n}
+		} else {
+			// int-range = first-pos "-" [ last-pos ]
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || !isDigits(first) {
+				return nil, true
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || !isDigits(last) || end < start {
+					return nil, true
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		total += r.length
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, false
+	}
+	if total > size {
+		// overlapping ranges would send more than the whole representation
+		return nil, true
+	}
+	return ranges, true
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// multipartRanges is a multipart/byteranges body, each part is a range of the
+// file with its own Content-Type and Content-Range
+type multipartRanges struct {
+	boundary string
+	ranges   []byteRange
+	headers  []string
+	end      string
+}
+
+func newMultipartRanges(ranges []byteRange, contentType string, size int64) *multipartRanges {
+	p := make([]byte, 16)
+	rand.Read(p)
+	mp := &multipartRanges{
+		boundary: hex.EncodeToString(p),
+		ranges:   ranges,
+	}
+	for _, r := range ranges {
+		mp.headers = append(mp.headers, fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", mp.boundary, contentType, r.contentRange(size)))
+	}
+	mp.end = "\r\n--" + mp.boundary + "--\r\n"
+	return mp
+}
+
+// length returns the length of the whole body
+func (mp *multipartRanges) length() int64 {
+	n := int64(len(mp.end))
+	for i, r := range mp.ranges {
+		n += int64(len(mp.headers[i])) + r.length
+	}
+	return n
+}
+
+func (mp *multipartRanges) writeTo(w io.Writer, f *os.File) error {
+	for i, r := range mp.ranges {
+		if _, err := io.WriteString(w, mp.headers[i]); err != nil {
+			return err
+		}
+		if err := copyRange(w, f, r); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, mp.end)
+	return err
+}
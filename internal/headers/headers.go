@@ -3,7 +3,9 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strings"
+	"unsafe"
 )
 
 var fieldLineDelimiter = []byte("\r\n")
@@ -13,6 +15,9 @@ var ErrMalformedHeaders = fmt.Errorf("headers: malformed headers")
 
 type Headers struct {
 	kv map[string]string
+	// multi holds the fields whose values are lines of their own, it is
+	// allocated by the first of them
+	multi map[string][]string
 }
 
 func NewHeaders() *Headers {
@@ -21,38 +26,115 @@ func NewHeaders() *Headers {
 	}
 }
 
+// isMultiLine reports whether the values of a field can't be combined in a
+// comma separated list: Set-Cookie, the exception of RFC 9110 5.3, and Cookie
+// whose list is separated by semicolons
+func isMultiLine(key string) bool {
+	return key == "set-cookie" || key == "cookie"
+}
+
+// Get returns the value of the field, the first one for fields added with Add
 func (h *Headers) Get(key string) string {
-	return h.kv[strings.ToLower(key)]
+	key = strings.ToLower(key)
+	if v, ok := h.kv[key]; ok {
+		return v
+	}
+	if vs := h.multi[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Values returns the values of the field, one per field line
+func (h *Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	if v, ok := h.kv[key]; ok {
+		return []string{v}
+	}
+	return h.multi[key]
 }
 
+// Set appends the value to the comma separated list of the field, except for
+// Set-Cookie and Cookie whose values are added as with Add
 func (h *Headers) Set(key, value string) {
-	oldValue := h.Get(key)
+	lkey := strings.ToLower(key)
+	if isMultiLine(lkey) {
+		h.Add(lkey, value)
+		return
+	}
+	oldValue := h.kv[lkey]
 	newValue := value
 	if oldValue != "" {
 		newValue = oldValue + ", " + value
 	}
-	h.kv[strings.ToLower(key)] = newValue
+	h.kv[lkey] = newValue
+}
+
+// Add adds a value written on a field line of its own
+func (h *Headers) Add(key, value string) {
+	key = strings.ToLower(key)
+	if h.multi == nil {
+		h.multi = make(map[string][]string)
+	}
+	if v, ok := h.kv[key]; ok {
+		h.multi[key] = append(h.multi[key], v)
+		delete(h.kv, key)
+	}
+	h.multi[key] = append(h.multi[key], value)
 }
 
 func (h *Headers) Replace(key, value string) {
-	h.kv[strings.ToLower(key)] = value
+	key = strings.ToLower(key)
+	delete(h.multi, key)
+	h.kv[key] = value
 }
 
 func (h *Headers) Delete(key string) {
-	delete(h.kv, strings.ToLower(key))
+	key = strings.ToLower(key)
+	delete(h.kv, key)
+	delete(h.multi, key)
 }
 
+// Clone returns a copy of the fields, multi-line fields keep their lines
+func (h *Headers) Clone() *Headers {
+	c := NewHeaders()
+	for k, v := range h.kv {
+		c.kv[k] = v
+	}
+	for k, vs := range h.multi {
+		if c.multi == nil {
+			c.multi = make(map[string][]string, len(h.multi))
+		}
+		c.multi[k] = slices.Clone(vs)
+	}
+	return c
+}
+
+// Reset removes all the fields, keeping the storage for reuse
+func (h *Headers) Reset() {
+	clear(h.kv)
+	clear(h.multi)
+}
+
+// Len returns the number of distinct fields
 func (h *Headers) Len() int {
-	return len(h.kv)
+	return len(h.kv) + len(h.multi)
 }
 
+// ForEach calls fn for each field line, once per value of the fields added
+// with Add
 func (h *Headers) ForEach(fn func(string, string)) {
 	for k, v := range h.kv {
 		fn(k, v)
 	}
+	for k, vs := range h.multi {
+		for _, v := range vs {
+			fn(k, v)
+		}
+	}
 }
 
-func isToken(key string) bool {
+func isToken(key []byte) bool {
 	for i := 0; i < len(key); i++ {
 		c := key[i]
 		if c >= 'a' && c <= 'z' {
@@ -73,26 +155,39 @@ func isToken(key string) bool {
 
 func Parse(data []byte, eof bool) (*Headers, int, error) {
 	h := NewHeaders()
-	if bytes.Index(data, fieldLineDelimiter) == 0 {
+	n, err := ParseInto(h, data, eof, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n == 0 {
+		return nil, 0, nil
+	}
+	return h, n, nil
+}
+
+// ParseInto is Parse storing the fields into h, which is reset first. It
+// returns 0 bytes read and no error when more data is needed, on error the
+// count is the offset of the offending bytes instead. With noCopy the
+// values reference data instead of being copied and uncommon field names are
+// lowercased in place, data must outlive h and must not be modified
+func ParseInto(h *Headers, data []byte, eof bool, noCopy bool) (int, error) {
+	h.Reset()
+	if bytes.HasPrefix(data, fieldLineDelimiter) {
 		// the starting of headers is \r\r -> no header
-		return h, len(fieldLineDelimiter), nil
+		return len(fieldLineDelimiter), nil
 	}
 
 	endi := bytes.Index(data, headersDelimiter)
 	if endi == -1 {
 		if eof {
 			// expect to have \r\n\r\n to mark the end of headers, but not exist
-			return nil, 0, ErrMalformedHeaders
+			return len(data), ErrMalformedHeaders
 		}
-		return nil, 0, nil
+		return 0, nil
 	}
 	n := 0
 	for {
 		linei := bytes.Index(data[n:], fieldLineDelimiter)
-		if linei == -1 {
-			return nil, 0, nil
-		}
-
 		if linei == 0 {
 			// end of headers
 			break
@@ -100,21 +195,100 @@ func Parse(data []byte, eof bool) (*Headers, int, error) {
 
 		// idx: the index starting from n -> need to take slice [n:n+idx]
 		buf := data[n : n+linei]
-		coloni := bytes.Index(buf, []byte(":"))
-		if coloni == -1 || (len(buf) >= coloni && buf[coloni-1] == ' ') {
-			return nil, 0, ErrMalformedHeaders
+		coloni := bytes.IndexByte(buf, ':')
+		if coloni <= 0 || buf[coloni-1] == ' ' {
+			return n, ErrMalformedHeaders
 		}
 
-		fieldName := strings.TrimSpace(string(buf[:coloni]))
-		fieldValue := strings.TrimSpace(string(buf[coloni+1:]))
-		if !isToken(fieldName) {
-			return nil, 0, ErrMalformedHeaders
+		name := bytes.TrimSpace(buf[:coloni])
+		if len(name) == 0 || !isToken(name) {
+			return n, ErrMalformedHeaders
 		}
+		value := bytes.TrimSpace(buf[coloni+1:])
 
+		var fieldName, fieldValue string
+		if noCopy {
+			fieldName = internInPlace(name)
+			fieldValue = unsafeString(value)
+		} else {
+			fieldName = intern(name)
+			fieldValue = string(value)
+		}
 		h.Set(fieldName, fieldValue)
 
 		n += linei + len(fieldLineDelimiter)
 	}
 
-	return h, endi + len(headersDelimiter), nil
+	return endi + len(headersDelimiter), nil
+}
+
+// commonNames holds the lowercase names of the fields most requests carry so
+// parsing them doesn't allocate
+var commonNames = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"accept", "accept-charset", "accept-encoding", "accept-language",
+		"authorization", "cache-control", "connection", "content-encoding",
+		"content-length", "content-type", "cookie", "date", "expect",
+		"forwarded", "host", "if-match", "if-modified-since", "if-none-match",
+		"if-range", "if-unmodified-since", "keep-alive", "origin", "pragma",
+		"range", "referer", "te", "trailer", "transfer-encoding", "upgrade",
+		"user-agent", "via", "x-forwarded-for", "x-forwarded-host",
+		"x-forwarded-proto", "x-real-ip", "x-request-id",
+	} {
+		commonNames[name] = name
+	}
+}
+
+// maxCommonNameLen bounds the stack buffer used to look up common names
+const maxCommonNameLen = 32
+
+// lookupCommon returns the interned lowercase form of name if it is a common
+// field name
+func lookupCommon(name []byte) (string, bool) {
+	if len(name) > maxCommonNameLen {
+		return "", false
+	}
+	var lower [maxCommonNameLen]byte
+	for i, c := range name {
+		lower[i] = toLower(c)
+	}
+	// the conversion in a map index expression doesn't allocate
+	s, ok := commonNames[string(lower[:len(name)])]
+	return s, ok
+}
+
+// intern returns the lowercase field name, common names are shared
+func intern(name []byte) string {
+	if s, ok := lookupCommon(name); ok {
+		return s
+	}
+	return strings.ToLower(string(name))
+}
+
+// internInPlace is intern lowercasing uncommon names inside name itself and
+// returning a string referencing it
+func internInPlace(name []byte) string {
+	if s, ok := lookupCommon(name); ok {
+		return s
+	}
+	for i, c := range name {
+		name[i] = toLower(c)
+	}
+	return unsafeString(name)
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func unsafeString(p []byte) string {
+	if len(p) == 0 {
+		return ""
+	}
+	return unsafe.String(&p[0], len(p))
 }
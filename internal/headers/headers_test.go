@@ -1,6 +1,9 @@
 package headers
 
 import (
+	"maps"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -48,6 +51,13 @@ func TestHeadersParse(t *testing.T) {
 			expectHeaders: map[string]string{},
 			expectReadLen: 0,
 		},
+		{
+			description:   "missing field name",
+			data:          ": localhost:42069\r\n\r\n",
+			expectErr:     ErrMalformedHeaders,
+			expectHeaders: map[string]string{},
+			expectReadLen: 0,
+		},
 		{
 			description:   "empty headers",
 			data:          "\r\n",
@@ -86,3 +96,135 @@ func TestHeadersParseMultipleValues(t *testing.T) {
 	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", h.Get("Set-Person"))
 	assert.Equal(t, n, 109)
 }
+
+func TestHeadersMultiLine(t *testing.T) {
+	h, _, err := Parse([]byte("Set-Cookie: a=1; Path=/\r\nSet-Cookie: b=2, c\r\nVary: Accept\r\n\r\n"), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c"}, h.Values("Set-Cookie"))
+	assert.Equal(t, "a=1; Path=/", h.Get("set-cookie"))
+	assert.Equal(t, []string{"Accept"}, h.Values("Vary"))
+	assert.Nil(t, h.Values("Missing"))
+	assert.Equal(t, 2, h.Len())
+
+	var lines []string
+	h.ForEach(func(k, v string) {
+		lines = append(lines, k+": "+v)
+	})
+	slices.Sort(lines)
+	assert.Equal(t, []string{"set-cookie: a=1; Path=/", "set-cookie: b=2, c", "vary: Accept"}, lines)
+
+	// Add keeps a value set before on a line of its own
+	h.Replace("Link", "</a>")
+	h.Add("Link", "</b>")
+	assert.Equal(t, []string{"</a>", "</b>"}, h.Values("Link"))
+	h.Replace("Link", "</c>")
+	assert.Equal(t, []string{"</c>"}, h.Values("Link"))
+	h.Delete("Set-Cookie")
+	assert.Empty(t, h.Get("Set-Cookie"))
+	h.Reset()
+	assert.Zero(t, h.Len())
+}
+
+// sameFields reports whether both headers hold the same field lines
+func sameFields(a, b *Headers) bool {
+	return maps.Equal(a.kv, b.kv) && maps.EqualFunc(a.multi, b.multi, slices.Equal)
+}
+
+func FuzzHeadersParse(f *testing.F) {
+	seeds := []string{
+		"Host: localhost:42069\r\nContent-Type: application/json\r\n\r\n",
+		"       Host: localhost:42069       \r\n\r\n",
+		"Host : localhost:42069\r\n\r\n",
+		"H@1ost: localhost:42069\r\n\r\n",
+		"Set-Person: a\r\nSet-Person: b\r\nSet-Person:\r\n\r\n",
+		": no-name\r\n\r\n",
+		"X-Empty:\r\n\r\n",
+		"Host: a\r\nbody",
+		"\r\n",
+		"\r\n\r\n",
+		"",
+	}
+	for _, s := range seeds {
+		f.Add([]byte(s), false)
+		f.Add([]byte(s), true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, eof bool) {
+		h, n, err := Parse(data, eof)
+		if n < 0 || n > len(data) {
+			t.Fatalf("consumed %d bytes of %d", n, len(data))
+		}
+		if err != nil || h == nil {
+			return
+		}
+
+		// the result only depends on the consumed bytes
+		again, an, err := Parse(data[:n], false)
+		if err != nil || an != n || !sameFields(again, h) {
+			t.Fatalf("parsing the consumed prefix: %v %d %v", again, an, err)
+		}
+
+		var b strings.Builder
+		for _, k := range slices.Sorted(maps.Keys(h.kv)) {
+			b.WriteString(k + ": " + h.kv[k] + "\r\n")
+		}
+		for _, k := range slices.Sorted(maps.Keys(h.multi)) {
+			for _, v := range h.multi[k] {
+				b.WriteString(k + ": " + v + "\r\n")
+			}
+		}
+		b.WriteString("\r\n")
+		again, an, err = Parse([]byte(b.String()), false)
+		if err != nil || an != b.Len() || !sameLists(again, h) {
+			t.Fatalf("parsing %q: %v %d %v", b.String(), again, an, err)
+		}
+	})
+}
+
+// sameLists is sameFields for headers written out and parsed again: an empty
+// element ending a list, as of "X: a\r\nX:", loses its trailing space on
+// the wire
+func sameLists(a, b *Headers) bool {
+	return maps.EqualFunc(a.kv, b.kv, func(x, y string) bool {
+		return normalizeList(x) == normalizeList(y)
+	}) && maps.EqualFunc(a.multi, b.multi, slices.Equal)
+}
+
+// normalizeList drops the empty elements of a comma separated list
+func normalizeList(v string) string {
+	var elems []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return strings.Join(elems, ", ")
+}
+
+var benchmarkHeaders = []byte("Host: api.example.com\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n" +
+	"Accept: application/json\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Content-Type: application/json\r\n" +
+	"X-Custom-Header: value\r\n" +
+	"\r\n")
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, err := Parse(benchmarkHeaders, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseIntoNoCopy(b *testing.B) {
+	h := NewHeaders()
+	data := slices.Clone(benchmarkHeaders)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := ParseInto(h, data, false, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
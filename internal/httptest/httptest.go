@@ -0,0 +1,142 @@
+// Package httptest provides utilities to test server.Handler implementations
+// without binding a fixed port: a ResponseRecorder capturing what a handler
+// writes, and a Server listening on an ephemeral loopback port
+package httptest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/phungducminh/httpfromtcp/internal/client"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+// RemoteAddr is the client address of requests created by NewRequest
+const RemoteAddr = "192.0.2.1:1234"
+
+// NewRequest creates a request as the server would hand it to a handler. The
+// Host header is set to example.com and Content-Length to the body length
+func NewRequest(method, target string, body []byte, kv ...string) *request.Request {
+	h := headers.NewHeaders()
+	h.Replace("Host", "example.com")
+	if len(body) > 0 {
+		h.Replace("Content-Length", strconv.Itoa(len(body)))
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	if body == nil {
+		body = []byte{}
+	}
+
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: target,
+			Method:        method,
+		},
+		Headers:    h,
+		Body:       body,
+		RemoteAddr: RemoteAddr,
+	}
+}
+
+// ResponseRecorder is a response.Writer recording the bytes a handler writes,
+// the response is parsed back with Result for assertions
+type ResponseRecorder struct {
+	*response.Writer
+
+	buf bytes.Buffer
+}
+
+func NewRecorder() *ResponseRecorder {
+	rec := &ResponseRecorder{}
+	rec.Writer = response.NewWriter(&rec.buf)
+	return rec
+}
+
+// Record runs the handler with the request against a new recorder
+func Record(h server.Handler, req *request.Request) *ResponseRecorder {
+	rec := NewRecorder()
+	h(rec.Writer, req)
+	return rec
+}
+
+// Bytes returns the raw bytes written so far
+func (rec *ResponseRecorder) Bytes() []byte {
+	return rec.buf.Bytes()
+}
+
+// Result parses the recorded bytes as a response to a GET request
+func (rec *ResponseRecorder) Result() (*Result, error) {
+	return rec.ResultFor("GET")
+}
+
+// ResultFor parses the recorded bytes as a response to a request with the
+// method, which decides whether the response has a body
+func (rec *ResponseRecorder) ResultFor(method string) (*Result, error) {
+	res, err := response.ResponseFromReader(bytes.NewReader(rec.buf.Bytes()), method)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Response: res, Body: body}, nil
+}
+
+// Result is a recorded response whose body has been read entirely
+type Result struct {
+	*response.Response
+	Body []byte
+}
+
+// StatusCode returns the status code of the response
+func (r *Result) StatusCode() response.StatusCode {
+	return r.StatusLine.StatusCode
+}
+
+// Server serves a handler on an ephemeral loopback port
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:41523
+	URL string
+
+	s      *server.Server
+	client *client.Client
+}
+
+// NewServer starts serving the handler, Close must be called once done
+func NewServer(h server.Handler) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("httptest: failed to listen on a loopback port: " + err.Error())
+	}
+
+	return &Server{
+		URL:    "http://" + l.Addr().String(),
+		s:      server.ServeListener(l, h),
+		client: client.NewClient(client.Config{}),
+	}
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.s.Addr()
+}
+
+// Client returns a client for the server, its idle connections are closed
+// with the server
+func (s *Server) Client() *client.Client {
+	return s.client
+}
+
+func (s *Server) Close() {
+	s.client.CloseIdleConnections()
+	s.s.Close()
+}
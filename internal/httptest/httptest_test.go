@@ -0,0 +1,78 @@
+package httptest
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body)
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("X-Remote-Addr", req.RemoteAddr)
+	w.WriteStatusLine(response.Created)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+func TestRecorder(t *testing.T) {
+	rec := Record(echoHandler, NewRequest("POST", "/users", []byte("hello")))
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.Created, res.StatusCode())
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Equal(t, RemoteAddr, res.Headers.Get("X-Remote-Addr"))
+	assert.Equal(t, "POST /users hello", string(res.Body))
+	assert.Contains(t, string(rec.Bytes()), "HTTP/1.1 201 Created\r\n")
+}
+
+func TestRecorderChunked(t *testing.T) {
+	rec := Record(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.Write([]byte("0\r\n"))
+		trailers := headers.NewHeaders()
+		trailers.Replace("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	}, NewRequest("GET", "/", nil))
+
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(res.Body))
+	assert.Equal(t, "abc", res.Trailers.Get("X-Checksum"))
+}
+
+func TestRecorderHead(t *testing.T) {
+	rec := Record(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+	}, NewRequest("HEAD", "/", nil))
+
+	res, err := rec.ResultFor("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "10", res.Headers.Get("Content-Length"))
+	assert.Empty(t, res.Body)
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(echoHandler)
+	defer s.Close()
+
+	res, err := s.Client().Get(context.Background(), s.URL+"/coffee")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, response.Created, res.StatusLine.StatusCode)
+	assert.Equal(t, "GET /coffee ", string(body))
+	assert.Contains(t, res.Headers.Get("X-Remote-Addr"), "127.0.0.1:")
+}
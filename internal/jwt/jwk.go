@@ -0,0 +1,212 @@
+package jwt
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrInvalidKey = fmt.Errorf("jwt: invalid key")
+
+// minRSABits is the smallest RSA modulus accepted, RFC 7518 requires 2048
+const minRSABits = 2048
+
+// Key is a key verifying tokens
+type Key struct {
+	// ID matches the kid header of tokens, tokens without one are tried
+	// against every key
+	ID string
+	// Algorithm restricts the key to one algorithm, when empty the key
+	// verifies the algorithm of its type
+	Algorithm string
+	// Key is a []byte secret for HS256, an *rsa.PublicKey for RS256, an
+	// *ecdsa.PublicKey on P-256 for ES256 or an ed25519.PublicKey for EdDSA
+	Key any
+}
+
+// KeySource provides the keys verifying tokens, it must be safe for
+// concurrent use
+type KeySource interface {
+	Keys() []Key
+}
+
+// KeySet is an in-memory KeySource
+type KeySet []Key
+
+func (s KeySet) Keys() []Key {
+	return s
+}
+
+// jwk is a JSON Web Key of RFC 7517, the public members only
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// ParseKeySet parses a JSON Web Key Set. Keys of unknown types and
+// encryption keys are skipped, malformed keys are an error
+//
+//	{"keys": [{"kty": "RSA", "kid": "..", "n": "..", "e": "AQAB"}, ...]}
+func ParseKeySet(data []byte) (KeySet, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	var keys KeySet
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %d: %v", ErrInvalidKey, i, err)
+		}
+		if key != nil {
+			keys = append(keys, Key{ID: k.Kid, Algorithm: k.Alg, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// key returns the key of the JWK, nil for unknown types
+func (k jwk) key() (any, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := decodeSegment(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("malformed k")
+		}
+		return secret, nil
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("malformed n")
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("malformed e")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+		}
+		return key, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := decodeSegment(k.X)
+		y, errY := decodeSegment(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("malformed x or y")
+		}
+		// ecdh checks the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("malformed x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// fileCheckInterval is how often a FileKeySet looks for changes of its file
+const fileCheckInterval = time.Second
+
+// FileKeySet is the key set of a JWKS file, reloaded when the file changes
+type FileKeySet struct {
+	path string
+
+	mu      sync.Mutex
+	keys    KeySet
+	modTime time.Time
+	size    int64
+	checked time.Time
+
+	// interval and now are replaced by tests
+	interval time.Duration
+	now      func() time.Time
+}
+
+// NewFileKeySet loads the JWKS file at path. The file is checked for changes
+// at most once a second as keys are looked up, a file which fails to load
+// then leaves the previous keys in use
+func NewFileKeySet(path string) (*FileKeySet, error) {
+	s := &FileKeySet{path: path, interval: fileCheckInterval, now: time.Now}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Keys returns the keys of the file, reloading it when it changed
+func (s *FileKeySet) Keys() []Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.checked) >= s.interval {
+		s.checked = now
+		if err := s.load(); err != nil {
+			slog.Warn("failed to reload key set", slog.String("path", s.path), slog.Any("err", err))
+		}
+	}
+	return s.keys
+}
+
+// load reads the file when its modification time or size changed
+func (s *FileKeySet) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if s.keys != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseKeySet(data)
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		keys = KeySet{}
+	}
+	s.keys, s.modTime, s.size = keys, info.ModTime(), info.Size()
+	return nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.Strict().DecodeString(s)
+}
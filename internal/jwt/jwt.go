@@ -0,0 +1,300 @@
+// Package jwt verifies the JSON Web Tokens of RFC 7519 sent as bearer tokens,
+// JWS compact serializations signed with HS256, RS256, ES256 or EdDSA, with
+// keys from a JWKS file or an in-memory key set
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/auth"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+var (
+	ErrNoKeys               = fmt.Errorf("jwt: a key source is required")
+	ErrMalformed            = fmt.Errorf("jwt: malformed token")
+	ErrUnsupportedAlgorithm = fmt.Errorf("jwt: unsupported algorithm")
+	ErrUnknownKey           = fmt.Errorf("jwt: no key for the token")
+	ErrInvalidSignature     = fmt.Errorf("jwt: invalid signature")
+	ErrExpired              = fmt.Errorf("jwt: token expired")
+	ErrNotValidYet          = fmt.Errorf("jwt: token not valid yet")
+	ErrInvalidIssuer        = fmt.Errorf("jwt: invalid issuer")
+	ErrInvalidAudience      = fmt.Errorf("jwt: invalid audience")
+)
+
+// Algorithms are the supported signature algorithms
+var Algorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+
+// Claims are the claims of a verified token
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// Raw holds every claim of the token, the registered ones included.
+	// Numbers are json.Number
+	Raw map[string]any
+}
+
+// Config configures Verifier and Middleware
+type Config struct {
+	// Keys verify the signatures, it is required
+	Keys KeySource
+	// Algorithms are the algorithms accepted, all of Algorithms when empty
+	Algorithms []string
+	// Issuer must be the iss claim when set
+	Issuer string
+	// Audience must be among the aud claim when set
+	Audience string
+	// Leeway tolerates clock skew with the issuer when checking exp and nbf
+	Leeway time.Duration
+	// Realm is the realm of the Bearer challenge, auth.DefaultRealm when
+	// empty
+	Realm string
+
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// Verifier verifies tokens, it is safe for concurrent use
+type Verifier struct {
+	cfg Config
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.Keys == nil {
+		return nil, ErrNoKeys
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = Algorithms
+	}
+	for _, alg := range cfg.Algorithms {
+		if !slices.Contains(Algorithms, alg) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+		}
+	}
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+	return &Verifier{cfg: cfg}, nil
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// Verify checks the signature of the token, then its time, issuer and
+// audience claims, and returns the claims
+//
+// JWS = BASE64URL(header) "." BASE64URL(payload) "." BASE64URL(signature)
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	rawHeader, errH := decodeSegment(parts[0])
+	payload, errP := decodeSegment(parts[1])
+	sig, errS := decodeSegment(parts[2])
+	if errH != nil || errP != nil || errS != nil {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return nil, ErrMalformed
+	}
+	// none of the extensions are understood
+	if len(h.Crit) > 0 || !slices.Contains(v.cfg.Algorithms, h.Alg) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, h.Alg)
+	}
+
+	signingInput := token[:len(parts[0])+1+len(parts[1])]
+	if err := v.verifySignature(h, []byte(signingInput), sig); err != nil {
+		return nil, err
+	}
+
+	claims, err := parseClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(h header, signingInput, sig []byte) error {
+	found := false
+	for _, key := range v.cfg.Keys.Keys() {
+		if h.Kid != "" && key.ID != h.Kid || key.Algorithm != "" && key.Algorithm != h.Alg {
+			continue
+		}
+		// the type of the key decides its algorithm, an RSA public key can't
+		// be used as an HMAC secret
+		ok, usable := verify(h.Alg, key.Key, signingInput, sig)
+		if !usable {
+			continue
+		}
+		found = true
+		if ok {
+			return nil
+		}
+	}
+	if !found {
+		return ErrUnknownKey
+	}
+	return ErrInvalidSignature
+}
+
+// verify reports whether sig is the signature of the input with the key,
+// usable is false when the key doesn't suit the algorithm
+func verify(alg string, key any, input, sig []byte) (ok, usable bool) {
+	digest := sha256.Sum256(input)
+	switch k := key.(type) {
+	case []byte:
+		if alg != "HS256" {
+			return false, false
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(input)
+		return hmac.Equal(sig, mac.Sum(nil)), true
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return false, false
+		}
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil, true
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || k.Curve.Params().Name != "P-256" {
+			return false, false
+		}
+		// r and s, 32 bytes each
+		if len(sig) != 64 {
+			return false, true
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s), true
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return false, false
+		}
+		return ed25519.Verify(k, input, sig), true
+	}
+	return false, false
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.cfg.now()
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt.Add(v.cfg.Leeway)) {
+		return ErrExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(v.cfg.Leeway).Before(c.NotBefore) {
+		return ErrNotValidYet
+	}
+	if v.cfg.Issuer != "" && c.Issuer != v.cfg.Issuer {
+		return ErrInvalidIssuer
+	}
+	if v.cfg.Audience != "" && !slices.Contains(c.Audience, v.cfg.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// maxNumericDate bounds numeric dates, well past the year 30000
+const maxNumericDate = 1e12
+
+// parseClaims parses the payload, the registered claims must have their
+// types: strings, numeric dates and aud as a string or an array of them
+func parseClaims(payload []byte) (*Claims, error) {
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	c := &Claims{}
+	if err := d.Decode(&c.Raw); err != nil || c.Raw == nil {
+		return nil, ErrMalformed
+	}
+
+	for name, dst := range map[string]*string{"iss": &c.Issuer, "sub": &c.Subject, "jti": &c.ID} {
+		if v, ok := c.Raw[name]; ok {
+			if *dst, ok = v.(string); !ok {
+				return nil, ErrMalformed
+			}
+		}
+	}
+	for name, dst := range map[string]*time.Time{"exp": &c.ExpiresAt, "nbf": &c.NotBefore, "iat": &c.IssuedAt} {
+		if v, ok := c.Raw[name]; ok {
+			n, _ := v.(json.Number)
+			f, err := n.Float64()
+			if err != nil || math.Abs(f) > maxNumericDate {
+				return nil, ErrMalformed
+			}
+			sec, frac := math.Modf(f)
+			*dst = time.Unix(int64(sec), int64(frac*1e9))
+		}
+	}
+	switch aud := c.Raw["aud"].(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, ErrMalformed
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		return nil, ErrMalformed
+	}
+	return c, nil
+}
+
+// Validate verifies the token for auth.BearerConfig, the principal is named
+// after the subject and its Claims are the *Claims of the token
+func (v *Verifier) Validate(token string) (*auth.Principal, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Principal{Name: claims.Subject, Claims: claims}, nil
+}
+
+// Middleware authenticates requests with the JWT of their Bearer
+// Authorization header, handlers get the claims with FromRequest. Requests
+// without a valid token are answered with 401 as by auth.Bearer
+func Middleware(cfg Config) (server.Middleware, error) {
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return auth.Bearer(auth.BearerConfig{Realm: cfg.Realm, Validate: v.Validate}), nil
+}
+
+// FromRequest returns the claims of the verified token of the request, nil
+// when the handler isn't wrapped by Middleware
+func FromRequest(req *request.Request) *Claims {
+	p := auth.FromRequest(req)
+	if p == nil {
+		return nil
+	}
+	c, _ := p.Claims.(*Claims)
+	return c
+}
@@ -0,0 +1,272 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	hmac    []byte
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return testKeys{hmac: []byte("0123456789abcdef0123456789abcdef"), rsa: rsaKey, ecdsa: ecKey, ed25519: edKey}
+}
+
+// jwks returns the JWKS of the public keys, with kids hs, rs, es and ed
+func (k testKeys) jwks() []byte {
+	pad := func(n *big.Int) string {
+		return b64.EncodeToString(n.FillBytes(make([]byte, 32)))
+	}
+	data, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(k.hmac)},
+		{"kty": "RSA", "kid": "rs", "alg": "RS256", "n": b64.EncodeToString(k.rsa.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": pad(k.ecdsa.X), "y": pad(k.ecdsa.Y)},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(k.ed25519.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "unknown", "kid": "future"},
+	}})
+	return data
+}
+
+// sign returns a token of the claims signed with the key of the algorithm
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	h, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	p, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(p)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "HS256":
+		m := hmac.New(sha256.New, k.hmac)
+		m.Write([]byte(input))
+		sig = m.Sum(nil)
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecdsa, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "EdDSA":
+		sig = ed25519.Sign(k.ed25519, []byte(input))
+	}
+	return input + "." + b64.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseKeySet(keys.jwks())
+	require.NoError(t, err)
+	require.Len(t, set, 4)
+
+	now := time.Unix(1_700_000_000, 0)
+	v, err := NewVerifier(Config{
+		Keys:     set,
+		Issuer:   "https://issuer.example.com",
+		Audience: "api",
+		Leeway:   30 * time.Second,
+		now:      func() time.Time { return now },
+	})
+	require.NoError(t, err)
+
+	claims := func(kv ...any) map[string]any {
+		c := map[string]any{
+			"iss":  "https://issuer.example.com",
+			"sub":  "alice",
+			"aud":  []string{"web", "api"},
+			"exp":  now.Add(time.Minute).Unix(),
+			"iat":  now.Unix(),
+			"role": "admin",
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			if kv[i+1] == nil {
+				delete(c, kv[i].(string))
+			} else {
+				c[kv[i].(string)] = kv[i+1]
+			}
+		}
+		return c
+	}
+
+	for alg, kid := range map[string]string{"HS256": "hs", "RS256": "rs", "ES256": "es", "EdDSA": "ed"} {
+		c, err := v.Verify(keys.sign(t, alg, kid, claims()))
+		require.NoError(t, err, alg)
+		assert.Equal(t, "alice", c.Subject, alg)
+		assert.Equal(t, []string{"web", "api"}, c.Audience, alg)
+		assert.True(t, now.Add(time.Minute).Equal(c.ExpiresAt), alg)
+		assert.Equal(t, "admin", c.Raw["role"], alg)
+
+		// without a kid every key is tried
+		_, err = v.Verify(keys.sign(t, alg, "", claims()))
+		require.NoError(t, err, alg)
+	}
+
+	tests := []struct {
+		description string
+		token       string
+		err         error
+	}{
+		{"single audience", keys.sign(t, "ES256", "es", claims("aud", "api")), nil},
+		{"expired within leeway", keys.sign(t, "ES256", "es", claims("exp", now.Add(-20*time.Second).Unix())), nil},
+		{"expired", keys.sign(t, "ES256", "es", claims("exp", now.Add(-time.Minute).Unix())), ErrExpired},
+		{"not valid yet within leeway", keys.sign(t, "ES256", "es", claims("nbf", now.Add(20*time.Second).Unix())), nil},
+		{"not valid yet", keys.sign(t, "ES256", "es", claims("nbf", now.Add(time.Minute).Unix())), ErrNotValidYet},
+		{"issuer", keys.sign(t, "ES256", "es", claims("iss", "https://evil.example.com")), ErrInvalidIssuer},
+		{"audience", keys.sign(t, "ES256", "es", claims("aud", "web")), ErrInvalidAudience},
+		{"no audience", keys.sign(t, "ES256", "es", claims("aud", nil)), ErrInvalidAudience},
+		{"malformed exp", keys.sign(t, "ES256", "es", claims("exp", "tomorrow")), ErrMalformed},
+		{"malformed aud", keys.sign(t, "ES256", "es", claims("aud", []any{"api", 1})), ErrMalformed},
+		{"unknown kid", keys.sign(t, "ES256", "other", claims()), ErrUnknownKey},
+		{"key of another algorithm", keys.sign(t, "HS256", "rs", claims()), ErrUnknownKey},
+		{"none", b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{}`)) + ".", ErrUnsupportedAlgorithm},
+		{"crit", b64.EncodeToString([]byte(`{"alg":"HS256","crit":["b64"]}`)) + ".e30.", ErrUnsupportedAlgorithm},
+		{"two parts", "a.b", ErrMalformed},
+		{"padding", keys.sign(t, "ES256", "es", claims()) + "=", ErrMalformed},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(tt.token)
+		if tt.err == nil {
+			assert.NoError(t, err, tt.description)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.description)
+		}
+	}
+
+	// a changed payload or signature
+	token := keys.sign(t, "RS256", "rs", claims())
+	other := keys.sign(t, "RS256", "rs", claims("sub", "mallory"))
+	_, err = v.Verify(token[:len(token)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	parts, otherParts := strings.Split(token, "."), strings.Split(other, ".")
+	_, err = v.Verify(parts[0] + "." + otherParts[1] + "." + parts[2])
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// algorithms can be restricted
+	v, err = NewVerifier(Config{Keys: set, Algorithms: []string{"EdDSA"}, now: func() time.Time { return now }})
+	require.NoError(t, err)
+	_, err = v.Verify(keys.sign(t, "HS256", "hs", claims()))
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	_, err = NewVerifier(Config{Keys: set, Algorithms: []string{"HS512"}})
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	_, err = NewVerifier(Config{})
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
+
+func TestParseKeySet(t *testing.T) {
+	for _, data := range []string{
+		`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`,
+		`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AAAA", "y": "AAAA"}]}`,
+		`{"keys": [{"kty": "EC", "crv": "P-384", "x": "AAAA", "y": "AAAA"}]}`,
+		`{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AAAA"}]}`,
+		`{"keys": [{"kty": "oct", "k": ""}]}`,
+		`{"keys": 1}`,
+	} {
+		_, err := ParseKeySet([]byte(data))
+		assert.ErrorIs(t, err, ErrInvalidKey, data)
+	}
+}
+
+func TestFileKeySet(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks(), 0o600))
+
+	s, err := NewFileKeySet(path)
+	require.NoError(t, err)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	v, err := NewVerifier(Config{Keys: s})
+	require.NoError(t, err)
+	token := keys.sign(t, "EdDSA", "ed", map[string]any{"sub": "alice"})
+	_, err = v.Verify(token)
+	require.NoError(t, err)
+
+	// rotated keys are picked up once the file changed
+	rotated := newTestKeys(t)
+	require.NoError(t, os.WriteFile(path, rotated.jwks(), 0o600))
+	require.NoError(t, os.Chtimes(path, now, now.Add(time.Minute)))
+	_, err = v.Verify(token)
+	require.NoError(t, err, "checked at most once a second")
+	now = now.Add(time.Second)
+	_, err = v.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = v.Verify(rotated.sign(t, "EdDSA", "ed", map[string]any{"sub": "alice"}))
+	require.NoError(t, err)
+
+	// a broken file leaves the keys in place
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	require.NoError(t, os.Chtimes(path, now, now.Add(2*time.Minute)))
+	now = now.Add(time.Second)
+	_, err = v.Verify(rotated.sign(t, "EdDSA", "ed", map[string]any{"sub": "alice"}))
+	require.NoError(t, err)
+
+	_, err = NewFileKeySet(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	keys := newTestKeys(t)
+	mw, err := Middleware(Config{Keys: KeySet{{ID: "hs", Key: keys.hmac}}, Realm: "api"})
+	require.NoError(t, err)
+	var claims *Claims
+	h := mw(func(w *response.Writer, req *request.Request) {
+		claims = FromRequest(req)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+
+	token := keys.sign(t, "HS256", "hs", map[string]any{"sub": "alice", "scope": "read"})
+	res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Authorization", "Bearer "+token)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusCode())
+	require.NotNil(t, claims)
+	assert.Equal(t, "alice", claims.Subject)
+	assert.Equal(t, "read", claims.Raw["scope"])
+
+	token = keys.sign(t, "HS256", "hs", map[string]any{"sub": "alice", "exp": 1})
+	res, err = httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Authorization", "Bearer "+token)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.Unauthorized, res.StatusCode())
+	assert.Equal(t, `Bearer realm="api", error="invalid_token", error_description="invalid token"`, res.Headers.Get("WWW-Authenticate"))
+
+	assert.Nil(t, FromRequest(httptest.NewRequest("GET", "/", nil)))
+	_, err = Middleware(Config{})
+	assert.ErrorIs(t, err, ErrNoKeys)
+}
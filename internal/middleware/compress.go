@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/negotiate"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+// DefaultMinCompressSize is the size under which a body isn't worth compressing
+const DefaultMinCompressSize = 1024
+
+// CompressConfig configures Compress, the zero value is usable
+type CompressConfig struct {
+	// Level is the compression level of compress/flate, DefaultCompression
+	// when 0
+	Level int
+	// MinSize is the Content-Length under which bodies are sent as is,
+	// DefaultMinCompressSize when 0. Bodies of unknown length are compressed
+	MinSize int64
+	// Skip reports whether a media type is already compressed. By default
+	// images, audio, video and archives are skipped
+	Skip func(mediaType string) bool
+}
+
+// Compress encodes the bodies of the responses with gzip or deflate, whichever
+// the Accept-Encoding of the request prefers. Compressed responses get a
+// Content-Encoding, are sent chunked and their strong ETag becomes weak. HEAD
+// responses get the header fields the GET response would have
+func Compress(cfg CompressConfig) server.Middleware {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if cfg.MinSize == 0 {
+		cfg.MinSize = DefaultMinCompressSize
+	}
+	if cfg.Skip == nil {
+		cfg.Skip = isCompressed
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+				if !cfg.compressible(statusCode, h) {
+					return
+				}
+				h.Set("Vary", "Accept-Encoding")
+				coding := negotiateEncoding(req)
+				if coding == "" {
+					return
+				}
+				h.Replace("Content-Encoding", coding)
+				if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+					h.Replace("ETag", "W/"+etag)
+				}
+				if req.RequestLine.Method == "HEAD" {
+					// the header fields of the GET response, without its body
+					h.Delete("Content-Length")
+					if h.Get("Transfer-Encoding") == "" {
+						h.Replace("Transfer-Encoding", "chunked")
+					}
+					return
+				}
+				w.SetEncoder(cfg.encoder(coding))
+			})
+			next(w, req)
+			w.Finish()
+		}
+	}
+}
+
+func (cfg CompressConfig) compressible(statusCode response.StatusCode, h *headers.Headers) bool {
+	switch {
+	case statusCode < 200, statusCode == response.NoContent, statusCode == response.NotModified:
+		return false
+	case statusCode == response.PartialContent, h.Get("Content-Range") != "":
+		// ranges are of the identity representation
+		return false
+	case h.Get("Content-Encoding") != "":
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < cfg.MinSize {
+			return false
+		}
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	return !cfg.Skip(strings.ToLower(strings.TrimSpace(mediaType)))
+}
+
+func (cfg CompressConfig) encoder(coding string) response.Encoder {
+	return func(dst io.Writer) io.WriteCloser {
+		var w io.WriteCloser
+		var err error
+		if coding == "gzip" {
+			w, err = gzip.NewWriterLevel(dst, cfg.Level)
+		} else {
+			// the deflate content coding is the zlib format
+			w, err = zlib.NewWriterLevel(dst, cfg.Level)
+		}
+		if err != nil {
+			// an invalid level, fall back to the default one
+			w = gzip.NewWriter(dst)
+		}
+		return w
+	}
+}
+
+// isCompressed reports whether a media type is already compressed
+func isCompressed(mediaType string) bool {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	switch typ {
+	case "image":
+		return subtype != "svg+xml" && subtype != "bmp" && subtype != "x-icon"
+	case "audio", "video":
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip",
+		"application/zstd", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed", "application/x-rar-compressed",
+		"application/pdf", "application/wasm", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding returns gzip or deflate, whichever Accept-Encoding prefers,
+// gzip on ties. It returns an empty string when the request has no
+// Accept-Encoding or prefers the response as is
+func negotiateEncoding(req *request.Request) string {
+	if req.Headers.Get("Accept-Encoding") == "" {
+		return ""
+	}
+	coding, err := negotiate.NegotiateEncoding(req, []string{"gzip", "deflate", "identity"})
+	if err != nil || coding == "identity" {
+		return ""
+	}
+	return coding
+}
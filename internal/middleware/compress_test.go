@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/client"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var text = strings.Repeat("all work and no play makes jack a dull boy\n", 100)
+
+func textHandler(contentType, body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("Content-Type", contentType)
+		h.Replace("ETag", `"v1"`)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func headersMap(h *headers.Headers) map[string][]string {
+	m := map[string][]string{}
+	h.ForEach(func(key, value string) {
+		m[key] = append(m[key], value)
+	})
+	return m
+}
+
+func TestCompress(t *testing.T) {
+	h := Compress(CompressConfig{})(textHandler("text/plain", text))
+
+	rec := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip, deflate"))
+	res, err := rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Headers.Get("Vary"))
+	assert.Equal(t, "chunked", res.Headers.Get("Transfer-Encoding"))
+	assert.Empty(t, res.Headers.Get("Content-Length"))
+	assert.Equal(t, `W/"v1"`, res.Headers.Get("ETag"))
+	assert.Less(t, len(res.Body), len(text))
+	zr, err := gzip.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+
+	rec = httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "deflate"))
+	res, err = rec.Result()
+	require.NoError(t, err)
+	assert.Equal(t, "deflate", res.Headers.Get("Content-Encoding"))
+	zr2, err := zlib.NewReader(bytes.NewReader(res.Body))
+	require.NoError(t, err)
+	body, err = io.ReadAll(zr2)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+}
+
+func TestCompressSkipped(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  func(w *response.Writer, req *request.Request)
+		req      *request.Request
+		wantVary bool
+	}{
+		{
+			name:     "no accept-encoding",
+			handler:  textHandler("text/plain", text),
+			req:      httptest.NewRequest("GET", "/", nil),
+			wantVary: true,
+		},
+		{
+			name:     "identity only",
+			handler:  textHandler("text/plain", text),
+			req:      httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip;q=0"),
+			wantVary: true,
+		},
+		{
+			name:    "tiny body",
+			handler: textHandler("text/plain", "hello"),
+			req:     httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip"),
+		},
+		{
+			name:    "compressed type",
+			handler: textHandler("image/png", text),
+			req:     httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip"),
+		},
+		{
+			name:    "compressed type with parameters",
+			handler: textHandler("Application/Zip; name=a.zip", text),
+			req:     httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip"),
+		},
+		{
+			name: "partial content",
+			handler: func(w *response.Writer, req *request.Request) {
+				h := response.GetDefaultHeaders(len(text))
+				h.Replace("Content-Range", "bytes 0-10/100000")
+				w.WriteStatusLine(response.PartialContent)
+				w.WriteHeaders(h)
+				w.WriteBody([]byte(text))
+			},
+			req: httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.Record(Compress(CompressConfig{})(tt.handler), tt.req)
+			res, err := rec.Result()
+			require.NoError(t, err)
+			assert.Empty(t, res.Headers.Get("Content-Encoding"))
+			assert.Empty(t, res.Headers.Get("Transfer-Encoding"))
+			assert.NotEmpty(t, res.Headers.Get("Content-Length"))
+			if tt.wantVary {
+				assert.Equal(t, "Accept-Encoding", res.Headers.Get("Vary"))
+			} else {
+				assert.Empty(t, res.Headers.Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestCompressHead(t *testing.T) {
+	h := Compress(CompressConfig{})(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(text))
+		h.Replace("ETag", `"v1"`)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method == "GET" {
+			w.WriteBody([]byte(text))
+		}
+	})
+
+	for _, coding := range []string{"gzip", "identity"} {
+		get, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Accept-Encoding", coding)).Result()
+		require.NoError(t, err, coding)
+		head, err := httptest.Record(h, httptest.NewRequest("HEAD", "/", nil, "Accept-Encoding", coding)).ResultFor("HEAD")
+		require.NoError(t, err, coding)
+		assert.Equal(t, headersMap(get.Headers), headersMap(head.Headers), coding)
+		assert.Empty(t, head.Body, coding)
+	}
+
+	// the compressed GET is chunked and its tag weak
+	res, err := httptest.Record(h, httptest.NewRequest("HEAD", "/", nil, "Accept-Encoding", "gzip")).ResultFor("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	assert.Equal(t, `W/"v1"`, res.Headers.Get("ETag"))
+	assert.Empty(t, res.Headers.Get("Content-Length"))
+}
+
+func TestCompressChunkedTrailers(t *testing.T) {
+	// the flow of the httpbin handler, whose trailers are of the decoded body
+	h := Compress(CompressConfig{})(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Replace("Content-Type", "application/json")
+		h.Replace("Transfer-Encoding", "chunked")
+		h.Replace("Trailer", "X-Content-Length")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		for i := 0; i < len(text); i += 32 {
+			w.WriteChunkedBody([]byte(text[i:min(i+32, len(text))]))
+		}
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Replace("X-Content-Length", strconv.Itoa(len(text)))
+		w.WriteTrailers(trailers)
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+	req, err := client.NewRequest("GET", s.URL+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Replace("Accept-Encoding", "gzip")
+	res, err := s.Client().Do(t.Context(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(body))
+	io.Copy(io.Discard, res.Body)
+	assert.Equal(t, strconv.Itoa(len(text)), res.Trailers.Get("X-Content-Length"))
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+// CORSConfig configures CORS, the zero value allows no origin
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to read the responses, as
+	// scheme://host[:port]. A * subdomain as in https://*.example.com allows
+	// the subdomains of example.com at any depth, but not example.com itself.
+	// A lone * allows any origin
+	AllowedOrigins []string
+	// AllowOrigin reports whether an origin matching none of AllowedOrigins is
+	// allowed
+	AllowOrigin func(origin string) bool
+	// AllowedMethods are the methods preflight requests may ask for, GET,
+	// HEAD and POST when empty
+	AllowedMethods []string
+	// AllowedHeaders are the request headers preflight requests may ask for,
+	// * allows any. CORS-safelisted headers like Accept need no listing
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read besides the
+	// CORS-safelisted ones
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization. The
+	// origin is then sent back instead of * even when any origin is allowed
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses, their default
+	// of 5 seconds when 0. It is sent in seconds, negative disables caching
+	MaxAge time.Duration
+}
+
+// CORS lets browsers send cross-origin requests from the allowed origins.
+// Preflight requests, OPTIONS with Origin and Access-Control-Request-Method,
+// are answered with 204 without reaching the handler, or with 403 when the
+// origin, method or headers aren't allowed. Other requests from an allowed
+// origin get the Access-Control-Allow-Origin of their response, from other
+// origins they are left alone and the browser keeps the response from the
+// script.
+//
+// Responses get Vary: Origin unless they allow any origin the same way
+func CORS(cfg CORSConfig) server.Middleware {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{"GET", "HEAD", "POST"}
+	}
+	c := &cors{
+		CORSConfig: cfg,
+		anyOrigin:  slices.Contains(cfg.AllowedOrigins, "*"),
+		anyHeader:  slices.Contains(cfg.AllowedHeaders, "*"),
+	}
+	// responses differ by origin unless they all get the literal *
+	c.varies = !c.anyOrigin || cfg.AllowCredentials
+	for _, o := range cfg.AllowedOrigins {
+		c.origins = append(c.origins, strings.ToLower(o))
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin := req.Headers.Get("Origin")
+			method := req.Headers.Get("Access-Control-Request-Method")
+			if req.RequestLine.Method == "OPTIONS" && origin != "" && method != "" {
+				c.preflight(w, req, origin, method)
+				return
+			}
+
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+				if c.varies {
+					h.Set("Vary", "Origin")
+				}
+				if origin == "" || !c.allowed(origin) {
+					return
+				}
+				c.allowOrigin(h, origin)
+				if len(cfg.ExposedHeaders) > 0 {
+					h.Replace("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+				}
+			})
+			next(w, req)
+		}
+	}
+}
+
+type cors struct {
+	CORSConfig
+	anyOrigin bool
+	anyHeader bool
+	varies    bool
+	// origins are the lower case AllowedOrigins
+	origins []string
+}
+
+func (c *cors) allowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	for _, o := range c.origins {
+		if matchOrigin(o, lower) {
+			return true
+		}
+	}
+	return c.AllowOrigin != nil && c.AllowOrigin(origin)
+}
+
+func (c *cors) allowOrigin(h *headers.Headers, origin string) {
+	if c.varies {
+		h.Replace("Access-Control-Allow-Origin", origin)
+	} else {
+		h.Replace("Access-Control-Allow-Origin", "*")
+	}
+	if c.AllowCredentials {
+		h.Replace("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) preflight(w *response.Writer, req *request.Request, origin, method string) {
+	w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+		h.Set("Vary", "Origin")
+		h.Set("Vary", "Access-Control-Request-Method")
+		h.Set("Vary", "Access-Control-Request-Headers")
+	})
+	if !c.allowed(origin) {
+		w.WriteError(response.Forbidden, server.NewHandlerError(response.Forbidden, "origin "+origin+" is not allowed"))
+		return
+	}
+	if !slices.Contains(c.AllowedMethods, method) {
+		w.WriteError(response.Forbidden, server.NewHandlerError(response.Forbidden, "method "+method+" is not allowed"))
+		return
+	}
+	var requested []string
+	for _, name := range strings.Split(req.Headers.Get("Access-Control-Request-Headers"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !c.anyHeader && !slices.ContainsFunc(c.AllowedHeaders, func(s string) bool { return strings.EqualFold(s, name) }) {
+			w.WriteError(response.Forbidden, server.NewHandlerError(response.Forbidden, "header "+name+" is not allowed"))
+			return
+		}
+		requested = append(requested, strings.ToLower(name))
+	}
+
+	h := headers.NewHeaders()
+	c.allowOrigin(h, origin)
+	h.Replace("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		h.Replace("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	switch {
+	case c.MaxAge > 0:
+		h.Replace("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	case c.MaxAge < 0:
+		h.Replace("Access-Control-Max-Age", "0")
+	}
+	w.WriteStatusLine(response.NoContent)
+	w.WriteHeaders(h)
+}
+
+// matchOrigin reports whether the lower case origin matches the pattern, an
+// origin or an origin with a * subdomain
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, ":/@") && !strings.HasPrefix(sub, ".") && !strings.HasSuffix(sub, ".")
+}
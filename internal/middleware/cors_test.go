@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://.example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://evil.com:1.example.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.com", false},
+		{"https://*.example.com:8443", "https://app.example.com:8443", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchOrigin(tt.pattern, tt.origin), tt.pattern+" "+tt.origin)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowOrigin:      func(origin string) bool { return strings.HasSuffix(origin, ".test") },
+		ExposedHeaders:   []string{"X-Request-Id", "ETag"},
+		AllowCredentials: true,
+	})(textHandler("text/plain", "hello"))
+
+	for _, origin := range []string{"https://app.example.com", "https://a.example.org", "http://local.test"} {
+		res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Origin", origin)).Result()
+		require.NoError(t, err)
+		assert.Equal(t, origin, res.Headers.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", res.Headers.Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "X-Request-Id, ETag", res.Headers.Get("Access-Control-Expose-Headers"))
+		assert.Equal(t, "Origin", res.Headers.Get("Vary"))
+		assert.Equal(t, "hello", string(res.Body))
+	}
+
+	for _, kv := range [][]string{{"Origin", "https://evil.com"}, nil} {
+		res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, kv...)).Result()
+		require.NoError(t, err)
+		assert.Empty(t, res.Headers.Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, res.Headers.Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "Origin", res.Headers.Get("Vary"))
+		assert.Equal(t, "hello", string(res.Body))
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := CORS(CORSConfig{AllowedOrigins: []string{"*"}})(textHandler("text/plain", "hello"))
+	res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Origin", "https://a.com")).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "*", res.Headers.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, res.Headers.Get("Vary"))
+
+	// credentials can't be shared with *
+	h = CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})(textHandler("text/plain", "hello"))
+	res, err = httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Origin", "https://a.com")).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "https://a.com", res.Headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", res.Headers.Get("Vary"))
+}
+
+func TestCORSPreflight(t *testing.T) {
+	called := false
+	h := CORS(CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         10 * time.Minute,
+	})(func(w *response.Writer, req *request.Request) {
+		called = true
+		textHandler("text/plain", "hello")(w, req)
+	})
+
+	preflight := func(kv ...string) *httptest.Result {
+		t.Helper()
+		kv = append([]string{"Origin", "https://app.example.com", "Access-Control-Request-Method", "PUT"}, kv...)
+		req := httptest.NewRequest("OPTIONS", "/items/1", nil)
+		for i := 0; i+1 < len(kv); i += 2 {
+			req.Headers.Replace(kv[i], kv[i+1])
+		}
+		res, err := httptest.Record(h, req).Result()
+		require.NoError(t, err)
+		return res
+	}
+
+	res := preflight("Access-Control-Request-Headers", "content-type, Authorization")
+	assert.Equal(t, response.NoContent, res.StatusCode())
+	assert.Equal(t, "https://app.example.com", res.Headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT, DELETE", res.Headers.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, authorization", res.Headers.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", res.Headers.Get("Access-Control-Max-Age"))
+	assert.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", res.Headers.Get("Vary"))
+	assert.Empty(t, res.Headers.Get("Access-Control-Allow-Credentials"))
+	assert.Empty(t, res.Body)
+	assert.False(t, called)
+
+	tests := []struct {
+		description string
+		kv          []string
+	}{
+		{"origin", []string{"Origin", "https://evil.com"}},
+		{"method", []string{"Access-Control-Request-Method", "PATCH"}},
+		{"header", []string{"Access-Control-Request-Headers", "Content-Type, X-Debug"}},
+	}
+	for _, tt := range tests {
+		res := preflight(tt.kv...)
+		assert.Equal(t, response.Forbidden, res.StatusCode(), tt.description)
+		assert.Empty(t, res.Headers.Get("Access-Control-Allow-Origin"), tt.description)
+		assert.Contains(t, res.Headers.Get("Vary"), "Origin", tt.description)
+	}
+	assert.False(t, called)
+
+	// OPTIONS without Access-Control-Request-Method isn't a preflight
+	res, err := httptest.Record(h, httptest.NewRequest("OPTIONS", "/", nil, "Origin", "https://app.example.com")).Result()
+	require.NoError(t, err)
+	assert.True(t, called)
+	assert.Equal(t, response.OK, res.StatusCode())
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+var (
+	ErrUnsupportedContentEncoding = fmt.Errorf("middleware: unsupported content-encoding")
+	ErrDecodedBodyTooLarge        = fmt.Errorf("middleware: decoded body too large")
+	ErrMalformedEncodedBody       = fmt.Errorf("middleware: malformed encoded body")
+)
+
+// DecompressConfig configures Decompress, the zero value is usable
+type DecompressConfig struct {
+	// MaxBytes bounds the decoded body, request.DefaultMaxBodyBytes when 0.
+	// A few compressed bytes can expand to gigabytes
+	MaxBytes int64
+}
+
+// Decompress decodes request bodies sent with a gzip or deflate
+// Content-Encoding, handlers get the decoded body without Content-Encoding.
+// Other encodings are answered with 415 and an Accept-Encoding listing the
+// supported ones, bodies decoding to more than MaxBytes with 413 and bodies
+// which fail to decode with 400
+func Decompress(cfg DecompressConfig) server.Middleware {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = request.DefaultMaxBodyBytes
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ce := req.Headers.Get("Content-Encoding")
+			if ce == "" {
+				next(w, req)
+				return
+			}
+
+			body, err := decodeBody(req.Body, ce, cfg.MaxBytes)
+			switch {
+			case errors.Is(err, ErrUnsupportedContentEncoding):
+				w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+					h.Replace("Accept-Encoding", "gzip, deflate")
+				})
+				w.WriteError(response.UnsupportedMediaType, server.NewHandlerError(response.UnsupportedMediaType, err.Error()))
+				return
+			case errors.Is(err, ErrDecodedBodyTooLarge):
+				w.WriteError(response.ContentTooLarge, server.NewHandlerError(response.ContentTooLarge, err.Error()))
+				return
+			case err != nil:
+				w.WriteError(response.BadRequest, server.NewHandlerError(response.BadRequest, err.Error()))
+				return
+			}
+
+			req.Body = body
+			req.Headers.Delete("Content-Encoding")
+			if req.Headers.Get("Content-Length") != "" {
+				req.Headers.Replace("Content-Length", strconv.Itoa(len(body)))
+			}
+			next(w, req)
+		}
+	}
+}
+
+// decodeBody undoes the codings of the Content-Encoding value, they are listed
+// in the order they were applied
+func decodeBody(body []byte, contentEncoding string, maxBytes int64) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for _, coding := range codings {
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip", "deflate", "identity", "":
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, strings.TrimSpace(coding))
+		}
+	}
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		var r io.ReadCloser
+		var err error
+		switch strings.ToLower(strings.TrimSpace(codings[i])) {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// the deflate content coding is the zlib format
+			r, err = zlib.NewReader(bytes.NewReader(body))
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedEncodedBody, err)
+		}
+		decoded, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedEncodedBody, err)
+		}
+		if int64(len(decoded)) > maxBytes {
+			return nil, ErrDecodedBodyTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strconv"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(p []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(p)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func deflated(p []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(p)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func echoBody(w *response.Writer, req *request.Request) {
+	h := response.GetDefaultHeaders(len(req.Body))
+	h.Replace("X-Content-Encoding", req.Headers.Get("Content-Encoding"))
+	h.Replace("X-Content-Length", req.Headers.Get("Content-Length"))
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteBody(req.Body)
+}
+
+func TestDecompress(t *testing.T) {
+	h := Decompress(DecompressConfig{MaxBytes: 1 << 10})(echoBody)
+	hello := []byte("hello world")
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		status   response.StatusCode
+		want     string
+	}{
+		{"plain", hello, "", response.OK, "hello world"},
+		{"gzip", gzipped(hello), "gzip", response.OK, "hello world"},
+		{"x-gzip", gzipped(hello), "X-Gzip", response.OK, "hello world"},
+		{"deflate", deflated(hello), "deflate", response.OK, "hello world"},
+		{"identity", hello, "identity", response.OK, "hello world"},
+		{"stacked", gzipped(deflated(hello)), "deflate, gzip", response.OK, "hello world"},
+		{"unsupported", hello, "br", response.UnsupportedMediaType, ""},
+		{"unsupported after gzip", gzipped(hello), "gzip, zstd", response.UnsupportedMediaType, ""},
+		{"malformed", hello, "gzip", response.BadRequest, ""},
+		{"truncated", gzipped(hello)[:15], "gzip", response.BadRequest, ""},
+		{"bomb", gzipped(make([]byte, 1<<20)), "gzip", response.ContentTooLarge, ""},
+		{"at limit", gzipped(make([]byte, 1<<10)), "gzip", response.OK, string(make([]byte, 1<<10))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", tt.body)
+			if tt.encoding != "" {
+				req.Headers.Replace("Content-Encoding", tt.encoding)
+			}
+			res, err := httptest.Record(h, req).Result()
+			require.NoError(t, err)
+			require.Equal(t, tt.status, res.StatusCode())
+			if tt.status != response.OK {
+				if tt.status == response.UnsupportedMediaType {
+					assert.Equal(t, "gzip, deflate", res.Headers.Get("Accept-Encoding"))
+				}
+				return
+			}
+			assert.Equal(t, tt.want, string(res.Body))
+			assert.Empty(t, res.Headers.Get("X-Content-Encoding"))
+			assert.Equal(t, strconv.Itoa(len(tt.want)), res.Headers.Get("X-Content-Length"))
+		})
+	}
+}
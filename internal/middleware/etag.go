@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+
+	"github.com/phungducminh/httpfromtcp/internal/conditional"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+// DefaultMaxETagBytes bounds the bodies held in memory to compute their ETag
+const DefaultMaxETagBytes = 1 << 20
+
+// ETagConfig configures ETag, the zero value is usable
+type ETagConfig struct {
+	// MaxBytes is the size of the largest body tagged, larger ones are sent
+	// as they are written. DefaultMaxETagBytes when 0
+	MaxBytes int
+}
+
+// ETag holds the 200 responses to GET requests in memory to give them a strong
+// ETag computed from their body, then evaluates the preconditions of the
+// request against it: the response becomes a 304 when the client has it
+// already and a 412 when an If-Match fails.
+//
+// HEAD requests are treated alike when their handler writes the body of the
+// GET response, whose Content-Length it matches, the body is then dropped.
+// HEAD responses without their body can't be tagged and are left alone.
+//
+// Responses which carry an ETag are written as they come, their handler
+// answers the preconditions itself, as do chunked responses and bodies larger
+// than MaxBytes. It suits small generated pages: wrap those handlers rather
+// than file servers or proxies, which would only be delayed by the hold
+func ETag(cfg ETagConfig) server.Middleware {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxETagBytes
+	}
+	untagged := func(statusCode response.StatusCode, h *headers.Headers) bool {
+		return statusCode == response.OK && h.Get("ETag") == ""
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			head := req.RequestLine.Method == "HEAD"
+			if req.RequestLine.Method != "GET" && !head {
+				next(w, req)
+				return
+			}
+
+			w.Hold(cfg.MaxBytes, untagged)
+			next(w, req)
+			_, h, body, ok := w.Held()
+			if !ok || head && h.Get("Content-Length") != strconv.Itoa(len(body)) {
+				w.Unhold()
+				return
+			}
+
+			sum := sha256.Sum256(body)
+			etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+			h.Replace("ETag", etag)
+			modTime, _ := conditional.ParseHTTPDate(h.Get("Last-Modified"))
+
+			switch conditional.Evaluate(req, etag, modTime) {
+			case conditional.NotModified:
+				w.DiscardHeld()
+				h.Delete("Content-Length")
+				w.WriteStatusLine(response.NotModified)
+				w.WriteHeaders(h)
+			case conditional.PreconditionFailed:
+				w.DiscardHeld()
+				w.WriteError(response.PreconditionFailed, server.NewHandlerError(response.PreconditionFailed, "the resource has changed"))
+			default:
+				if head {
+					w.DiscardHeld()
+					w.WriteStatusLine(response.OK)
+					w.WriteHeaders(h)
+					return
+				}
+				w.Unhold()
+			}
+		}
+	}
+}
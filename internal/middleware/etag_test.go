@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/fileserver"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETag(t *testing.T) {
+	untagged := func(w *response.Writer, req *request.Request) {
+		body := "hello"
+		if req.RequestLine.RequestTarget == "/v2" {
+			body = "hello v2"
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+	h := ETag(ETagConfig{})(untagged)
+
+	res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil)).Result()
+	require.NoError(t, err)
+	etag := res.Headers.Get("ETag")
+	assert.Regexp(t, `^"[A-Za-z0-9_-]{24}"$`, etag)
+	assert.Equal(t, "hello", string(res.Body))
+	res2, _ := httptest.Record(h, httptest.NewRequest("GET", "/v2", nil)).Result()
+	assert.NotEqual(t, etag, res2.Headers.Get("ETag"))
+
+	tests := []struct {
+		description string
+		kv          []string
+		status      response.StatusCode
+	}{
+		{"fresh", []string{"If-None-Match", etag}, response.NotModified},
+		{"fresh weak", []string{"If-None-Match", `"a", W/` + etag}, response.NotModified},
+		{"stale", []string{"If-None-Match", `"old"`}, response.OK},
+		{"if-match", []string{"If-Match", etag}, response.OK},
+		{"if-match failed", []string{"If-Match", `"old"`}, response.PreconditionFailed},
+	}
+	for _, tt := range tests {
+		res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, tt.kv...)).Result()
+		require.NoError(t, err, tt.description)
+		assert.Equal(t, tt.status, res.StatusCode(), tt.description)
+		switch tt.status {
+		case response.NotModified:
+			assert.Equal(t, etag, res.Headers.Get("ETag"), tt.description)
+			assert.Empty(t, res.Headers.Get("Content-Length"), tt.description)
+			assert.Empty(t, res.Body, tt.description)
+		case response.OK:
+			assert.Equal(t, "hello", string(res.Body), tt.description)
+		}
+	}
+}
+
+func TestETagHead(t *testing.T) {
+	page := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len("hello")))
+		w.WriteBody([]byte("hello"))
+	}
+	h := ETag(ETagConfig{})(page)
+	get, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil)).Result()
+	require.NoError(t, err)
+	etag := get.Headers.Get("ETag")
+
+	// the body written for HEAD is dropped
+	rec := httptest.Record(h, httptest.NewRequest("HEAD", "/", nil))
+	assert.True(t, strings.HasSuffix(string(rec.Bytes()), "\r\n\r\n"))
+	res, err := rec.ResultFor("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Equal(t, etag, res.Headers.Get("ETag"))
+	assert.Equal(t, "5", res.Headers.Get("Content-Length"))
+
+	res, err = httptest.Record(h, httptest.NewRequest("HEAD", "/", nil, "If-None-Match", etag)).ResultFor("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, response.NotModified, res.StatusCode())
+	res, err = httptest.Record(h, httptest.NewRequest("HEAD", "/", nil, "If-Match", `"old"`)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.PreconditionFailed, res.StatusCode())
+
+	// without the body of the GET response there is nothing to tag
+	headOnly := ETag(ETagConfig{})(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len("hello")))
+	})
+	res, err = httptest.Record(headOnly, httptest.NewRequest("HEAD", "/", nil, "If-None-Match", etag)).ResultFor("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Empty(t, res.Headers.Get("ETag"))
+	assert.Equal(t, "5", res.Headers.Get("Content-Length"))
+}
+
+func TestETagHandlerTag(t *testing.T) {
+	// the handler answers the preconditions of its own tags
+	h := ETag(ETagConfig{})(textHandler("text/plain", "hello"))
+	res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "If-None-Match", `"v1"`)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, res.StatusCode())
+	assert.Equal(t, `"v1"`, res.Headers.Get("ETag"))
+	assert.Equal(t, "hello", string(res.Body))
+}
+
+func TestETagSkipped(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler server.Handler
+		req     *request.Request
+	}{
+		{"post", textHandler("text/plain", "hello"), httptest.NewRequest("POST", "/", nil)},
+		{"too large", textHandler("text/plain", strings.Repeat("a", 11)), httptest.NewRequest("GET", "/", nil)},
+		{
+			"not found",
+			func(w *response.Writer, req *request.Request) {
+				w.WriteError(response.NotFound, server.NewHandlerError(response.NotFound, "nope"))
+			},
+			httptest.NewRequest("GET", "/", nil),
+		},
+		{
+			"chunked",
+			func(w *response.Writer, req *request.Request) {
+				h := headers.NewHeaders()
+				h.Replace("Transfer-Encoding", "chunked")
+				w.WriteStatusLine(response.OK)
+				w.WriteHeaders(h)
+				w.WriteChunkedBody([]byte("hello"))
+				w.WriteChunkedBodyDone()
+				w.WriteTrailers(headers.NewHeaders())
+			},
+			httptest.NewRequest("GET", "/", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := httptest.Record(tt.handler, tt.req).Result()
+			require.NoError(t, err)
+			tt.req.Headers.Replace("If-None-Match", "*")
+			tagged, err := httptest.Record(ETag(ETagConfig{MaxBytes: 10})(tt.handler), tt.req).Result()
+			require.NoError(t, err)
+			assert.Equal(t, plain.StatusCode(), tagged.StatusCode())
+			assert.Equal(t, plain.Headers.Get("ETag"), tagged.Headers.Get("ETag"))
+			assert.Equal(t, plain.Headers.Get("Content-Length"), tagged.Headers.Get("Content-Length"))
+			assert.Equal(t, plain.Body, tagged.Body)
+		})
+	}
+}
+
+func TestETagWithCompression(t *testing.T) {
+	untagged := func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(len(text))
+		h.Replace("Content-Type", "text/plain")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(text))
+	}
+	h := server.Chain(untagged, Compress(CompressConfig{}), ETag(ETagConfig{}))
+	res, err := httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip")).Result()
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Headers.Get("Content-Encoding"))
+	etag := res.Headers.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `W/"`), etag)
+
+	// the weak tag the client got matches
+	res, err = httptest.Record(h, httptest.NewRequest("GET", "/", nil, "Accept-Encoding", "gzip", "If-None-Match", etag)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.NotModified, res.StatusCode())
+	assert.Empty(t, res.Headers.Get("Content-Encoding"))
+	assert.Empty(t, res.Headers.Get("Transfer-Encoding"))
+}
+
+// sendfileConn counts the bytes of files the writer hands to the connection,
+// which *net.TCPConn sends with sendfile
+type sendfileConn struct {
+	*net.TCPConn
+	fromFiles int64
+}
+
+func (c *sendfileConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := c.TCPConn.ReadFrom(r)
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r = lr.R
+	}
+	if _, ok := r.(*os.File); ok {
+		c.fromFiles += n
+	}
+	return n, err
+}
+
+func TestETagFileOnTCP(t *testing.T) {
+	dir := t.TempDir()
+	body := strings.Repeat("0123456789abcdef", 4096)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "body"), []byte(body), 0o644))
+	files, err := fileserver.New(fileserver.Config{Root: dir})
+	require.NoError(t, err)
+	t.Cleanup(func() { files.Close() })
+
+	copyFile := func(length bool) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			f, err := os.Open(filepath.Join(dir, "body"))
+			require.NoError(t, err)
+			defer f.Close()
+			h := headers.NewHeaders()
+			if length {
+				h.Replace("Content-Length", strconv.Itoa(len(body)))
+			}
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(h)
+			// limited as by the file server, os.File.WriteTo would hide the file
+			io.Copy(w, io.LimitReader(f, int64(len(body))))
+		}
+	}
+	tests := []struct {
+		description string
+		handler     server.Handler
+		fromFiles   int
+	}{
+		{"file server", func(w *response.Writer, req *request.Request) { files.ServeFile(w, req, "body") }, len(body)},
+		{"declared too large", copyFile(true), len(body)},
+		// the first MaxBytes+1 bytes are held before the hold gives up
+		{"spilled", copyFile(false), len(body) - 1025},
+	}
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				received <- nil
+				return
+			}
+			defer conn.Close()
+			p, _ := io.ReadAll(conn)
+			received <- p
+		}()
+		conn, err := l.Accept()
+		l.Close()
+		require.NoError(t, err, tt.description)
+
+		sc := &sendfileConn{TCPConn: conn.(*net.TCPConn)}
+		ETag(ETagConfig{MaxBytes: 1024})(tt.handler)(response.NewWriter(sc), httptest.NewRequest("GET", "/body", nil))
+		sc.CloseWrite()
+		p := <-received
+		conn.Close()
+
+		assert.True(t, strings.HasSuffix(string(p), "\r\n\r\n"+body), tt.description)
+		assert.Equal(t, int64(tt.fromFiles), sc.fromFiles, tt.description)
+	}
+}
@@ -0,0 +1,259 @@
+// Package negotiate parses the Accept, Accept-Language and Accept-Encoding
+// headers of RFC 9110 section 12 and picks the representation a request
+// prefers among the ones a handler offers
+package negotiate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+)
+
+// ErrNotAcceptable is returned when none of the offers is acceptable, handlers
+// answer it with 406 Not Acceptable, or ignore the header and send their
+// default representation
+var ErrNotAcceptable = fmt.Errorf("no acceptable representation")
+
+// Spec is an element of an Accept, Accept-Language or Accept-Encoding list
+type Spec struct {
+	// Value is the media range, language range or content coding, in lower
+	// case
+	Value string
+	// Params are the parameters of a media range, the weight excluded
+	Params map[string]string
+	// Q is the weight, between 0 and 1. Elements with a malformed weight are
+	// given 0, not acceptable
+	Q float64
+
+	specificity int
+}
+
+// ParseAccept parses the media ranges of an Accept value, most preferred
+// first: by weight, then from the most specific range to */*
+//
+// Accept = #( media-range [ weight ] )
+func ParseAccept(s string) []Spec {
+	return parse(s, func(spec *Spec) {
+		typ, subtype, _ := strings.Cut(spec.Value, "/")
+		switch {
+		case typ == "*":
+			spec.specificity = 0
+		case subtype == "*":
+			spec.specificity = 1
+		default:
+			spec.specificity = 2 + len(spec.Params)
+		}
+	})
+}
+
+// ParseAcceptLanguage parses the language ranges of an Accept-Language value,
+// most preferred first: by weight, then from the range with the most subtags
+// to *
+//
+// Accept-Language = #( language-range [ weight ] )
+func ParseAcceptLanguage(s string) []Spec {
+	return parse(s, func(spec *Spec) {
+		if spec.Value != "*" {
+			spec.specificity = strings.Count(spec.Value, "-") + 1
+		}
+	})
+}
+
+// ParseAcceptEncoding parses the content codings of an Accept-Encoding value,
+// most preferred first: by weight, then codings before *
+//
+// Accept-Encoding = #( codings [ weight ] )
+func ParseAcceptEncoding(s string) []Spec {
+	return parse(s, func(spec *Spec) {
+		if spec.Value != "*" {
+			spec.specificity = 1
+		}
+	})
+}
+
+func parse(s string, rank func(spec *Spec)) []Spec {
+	var specs []Spec
+	for _, elem := range splitList(s, ',') {
+		params := splitList(elem, ';')
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if value == "" {
+			continue
+		}
+		spec := Spec{Value: value, Q: 1}
+		for _, param := range params[1:] {
+			name, v, _ := strings.Cut(param, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			v = strings.TrimSpace(v)
+			if name == "q" {
+				spec.Q = qvalue(v)
+				// what follows the weight are extensions
+				break
+			}
+			if name == "" {
+				continue
+			}
+			if spec.Params == nil {
+				spec.Params = map[string]string{}
+			}
+			if uq, err := strconv.Unquote(v); err == nil && strings.HasPrefix(v, `"`) {
+				v = uq
+			}
+			spec.Params[name] = v
+		}
+		rank(&spec)
+		specs = append(specs, spec)
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		if specs[i].Q != specs[j].Q {
+			return specs[i].Q > specs[j].Q
+		}
+		return specs[i].specificity > specs[j].specificity
+	})
+	return specs
+}
+
+// splitList splits s at sep outside of quoted strings
+func splitList(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// qvalue parses a weight, 0 when malformed
+//
+// qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] )
+func qvalue(s string) float64 {
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0
+	}
+	return q
+}
+
+// Negotiate returns the media type of offers the Accept header of the request
+// prefers, the first offer when the request has none. An offer is weighted by
+// the most specific range matching it, ties go to the offer matched by the
+// most specific range, then to the earliest offer. Parameters of a range only
+// match offers with the same parameters, as in text/html;level=1.
+//
+// It returns ErrNotAcceptable when no offer is acceptable
+func Negotiate(req *request.Request, offers []string) (string, error) {
+	return negotiate(req, "Accept", offers, ParseAccept, matchMediaType, "")
+}
+
+// NegotiateLanguage returns the language tag of offers the Accept-Language
+// header of the request prefers, the first offer when the request has none.
+// A range matches the tags it is a prefix of, en matches en-US. Ties are
+// broken as in Negotiate.
+//
+// It returns ErrNotAcceptable when no offer is acceptable
+func NegotiateLanguage(req *request.Request, offers []string) (string, error) {
+	return negotiate(req, "Accept-Language", offers, ParseAcceptLanguage, matchLanguage, "")
+}
+
+// NegotiateEncoding returns the content coding of offers the Accept-Encoding
+// header of the request prefers, the first offer when the request has none.
+// x-gzip is taken as gzip. The identity offer is acceptable unless refused
+// by identity;q=0 or *;q=0, it is then least preferred. Ties are broken as in
+// Negotiate.
+//
+// It returns ErrNotAcceptable when no offer is acceptable
+func NegotiateEncoding(req *request.Request, offers []string) (string, error) {
+	return negotiate(req, "Accept-Encoding", offers, ParseAcceptEncoding, matchEncoding, "identity")
+}
+
+// match returns the specificity of the match of spec and offer, -1 when they
+// don't match
+type match func(spec Spec, offer string) int
+
+// negotiate picks the offer of the header field. The implicit offer is
+// acceptable when no element matches it, after any offer matched
+func negotiate(req *request.Request, name string, offers []string, parse func(string) []Spec, match match, implicit string) (string, error) {
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+	values := req.Headers.Values(name)
+	if len(values) == 0 {
+		return offers[0], nil
+	}
+	specs := parse(strings.Join(values, ","))
+
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, spec := range specs {
+			// the most specific element matching the offer sets its weight
+			if s := match(spec, offer); s > specificity {
+				q, specificity = spec.Q, s
+			}
+		}
+		if specificity < 0 && implicit != "" && strings.EqualFold(offer, implicit) {
+			// the lowest weight there is
+			q = 0.001
+		}
+		if q > bestQ || q == bestQ && q > 0 && specificity > bestSpecificity {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	if best == "" {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
+
+func matchMediaType(spec Spec, offer string) int {
+	mediaType, params, _ := strings.Cut(offer, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	typ, _, _ := strings.Cut(mediaType, "/")
+	switch spec.Value {
+	case "*/*", typ + "/*":
+		return spec.specificity
+	case mediaType:
+	default:
+		return -1
+	}
+	if len(spec.Params) > 0 {
+		offered := ParseAccept("x/x;" + params)
+		if len(offered) == 0 {
+			return -1
+		}
+		for k, v := range spec.Params {
+			if !strings.EqualFold(offered[0].Params[k], v) {
+				return -1
+			}
+		}
+	}
+	return spec.specificity
+}
+
+func matchLanguage(spec Spec, offer string) int {
+	offer = strings.ToLower(offer)
+	if spec.Value == "*" || spec.Value == offer || strings.HasPrefix(offer, spec.Value+"-") {
+		return spec.specificity
+	}
+	return -1
+}
+
+func matchEncoding(spec Spec, offer string) int {
+	offer = strings.ToLower(offer)
+	if spec.Value == "*" || spec.Value == offer || spec.Value == "x-gzip" && offer == "gzip" {
+		return spec.specificity
+	}
+	return -1
+}
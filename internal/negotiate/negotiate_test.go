@@ -0,0 +1,154 @@
+package negotiate
+
+import (
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRequest returns a request with the header fields of kv, pairs of names
+// and values. httptest can't be used, server imports negotiate
+func newRequest(kv ...string) *request.Request {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return &request.Request{Headers: h}
+}
+
+func TestParseAccept(t *testing.T) {
+	specs := ParseAccept(`text/*;q=0.5, */*;q=0.1, text/html;level=1;q=0.5, application/json, text/html;q=0.5, TEXT/PLAIN;FORMAT="a,b";q=0.5;ext=1, , image/png;q=x`)
+	var values []string
+	for _, s := range specs {
+		values = append(values, s.Value)
+	}
+	assert.Equal(t, []string{"application/json", "text/html", "text/plain", "text/html", "text/*", "*/*", "image/png"}, values)
+	assert.Equal(t, map[string]string{"level": "1"}, specs[1].Params)
+	assert.Equal(t, map[string]string{"format": "a,b"}, specs[2].Params)
+	assert.Equal(t, 0.5, specs[2].Q)
+	assert.Equal(t, 0.0, specs[6].Q)
+	assert.Empty(t, ParseAccept(""))
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	specs := ParseAcceptLanguage("*;q=0.5, en, fr-CA;q=0.8, fr;q=0.8")
+	var values []string
+	for _, s := range specs {
+		values = append(values, s.Value)
+	}
+	assert.Equal(t, []string{"en", "fr-ca", "fr", "*"}, values)
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	specs := ParseAcceptEncoding("*;q=0.1, GZIP;Q=0.8, br")
+	var values []string
+	for _, s := range specs {
+		values = append(values, s.Value)
+	}
+	assert.Equal(t, []string{"br", "gzip", "*"}, values)
+	assert.Equal(t, 0.8, specs[1].Q)
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/plain", "text/html", "application/json"}
+	tests := []struct {
+		accept string
+		expect string
+	}{
+		{"*/*", "text/plain"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html"},
+		{"application/json", "application/json"},
+		{"application/*", "application/json"},
+		{"text/*;q=0.5, application/json;q=0.6", "application/json"},
+		{"text/*, text/plain;q=0", "text/html"},
+		{"TEXT/HTML;Q=0.9, text/plain;q=0.1", "text/html"},
+		{"text/plain;format=flowed", ""},
+		{"image/png", ""},
+		{"*/*;q=0", ""},
+	}
+	for _, tt := range tests {
+		got, err := Negotiate(newRequest("Accept", tt.accept), offers)
+		if tt.expect == "" {
+			assert.ErrorIs(t, err, ErrNotAcceptable, tt.accept)
+			continue
+		}
+		require.NoError(t, err, tt.accept)
+		assert.Equal(t, tt.expect, got, tt.accept)
+	}
+
+	got, err := Negotiate(newRequest(), offers)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", got)
+
+	// parameters of the range must be offered
+	got, err = Negotiate(newRequest("Accept", "text/html;level=1, text/html;q=0.1"),
+		[]string{"text/html", "text/html;level=1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text/html;level=1", got)
+
+	_, err = Negotiate(newRequest(), nil)
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	offers := []string{"en-US", "fr", "de-CH"}
+	tests := []struct {
+		accept string
+		expect string
+	}{
+		{"*", "en-US"},
+		{"fr", "fr"},
+		{"de", "de-CH"},
+		{"en-GB, fr;q=0.5", "fr"},
+		{"en, en-US;q=0.2, fr;q=0.5", "fr"},
+		{"*;q=0.5, de-ch", "de-CH"},
+		{"fr-CA", ""},
+	}
+	for _, tt := range tests {
+		got, err := NegotiateLanguage(newRequest("Accept-Language", tt.accept), offers)
+		if tt.expect == "" {
+			assert.ErrorIs(t, err, ErrNotAcceptable, tt.accept)
+			continue
+		}
+		require.NoError(t, err, tt.accept)
+		assert.Equal(t, tt.expect, got, tt.accept)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{"gzip", "deflate", "identity"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "identity"},
+		{"identity", "identity"},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"deflate;q=0.5, gzip;q=0.5", "gzip"},
+		{"*", "gzip"},
+		{"*;q=0.1, deflate;q=0.2", "deflate"},
+		{"gzip;q=0, deflate;q=0", "identity"},
+		{"*, gzip;q=0", "deflate"},
+		{"x-gzip", "gzip"},
+		{"GZIP;Q=0.8", "gzip"},
+		{"gzip;q=2", "identity"},
+		{"gzip;q=0.5, identity", "identity"},
+		{"*;q=0", ""},
+		{"br, identity;q=0", ""},
+	}
+	for _, tt := range tests {
+		got, err := NegotiateEncoding(newRequest("Accept-Encoding", tt.accept), offers)
+		if tt.want == "" {
+			assert.ErrorIs(t, err, ErrNotAcceptable, tt.accept)
+			continue
+		}
+		require.NoError(t, err, tt.accept)
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+}
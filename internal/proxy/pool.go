@@ -0,0 +1,390 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+const (
+	defaultMaxFails            = 3
+	defaultEjectDuration       = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+
+	// virtualNodes is the number of points each upstream owns on the hash ring
+	virtualNodes = 100
+)
+
+var ErrNoHealthyUpstream = fmt.Errorf("proxy: no healthy upstream")
+
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round-robin"
+	LeastConnections Strategy = "least-connections"
+	ConsistentHash   Strategy = "consistent-hash"
+)
+
+type PoolConfig struct {
+	// Strategy selects how upstreams are picked, round-robin by default
+	Strategy Strategy
+	// HashHeader is the request header hashed by ConsistentHash, the client IP
+	// is hashed when it is empty or the header is missing
+	HashHeader string
+
+	// MaxFails consecutive failures eject an upstream for EjectDuration
+	MaxFails      int
+	EjectDuration time.Duration
+
+	// HealthCheckPath enables active health checks, each upstream receives a
+	// GET request to that path every HealthCheckInterval and is considered
+	// healthy when it answers with a 2xx or 3xx status
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+}
+
+// Upstream is a member of a Pool, its counters are updated concurrently
+type Upstream struct {
+	URL *url.URL
+
+	healthy      atomic.Bool
+	active       atomic.Int64
+	requests     atomic.Int64
+	failures     atomic.Int64
+	consecutive  atomic.Int64
+	ejectedUntil atomic.Int64
+}
+
+func (u *Upstream) available(now time.Time) bool {
+	return u.healthy.Load() && now.UnixNano() >= u.ejectedUntil.Load()
+}
+
+// UpstreamState is a snapshot of an upstream exposed by the admin endpoint
+type UpstreamState struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	Ejected             bool       `json:"ejected"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveConnections   int64      `json:"active_connections"`
+	Requests            int64      `json:"requests"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+}
+
+type ringNode struct {
+	hash     uint64
+	upstream *Upstream
+}
+
+// Pool distributes requests across upstreams and tracks their health
+type Pool struct {
+	cfg       PoolConfig
+	upstreams []*Upstream
+	ring      []ringNode
+	next      atomic.Uint64
+
+	// dial connects to upstreams for health checks
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewPool(upstreams []string, cfg PoolConfig) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, ErrNoUpstream
+	}
+	switch cfg.Strategy {
+	case "":
+		cfg.Strategy = RoundRobin
+	case RoundRobin, LeastConnections, ConsistentHash:
+	default:
+		return nil, fmt.Errorf("proxy: unknown balancing strategy %q", cfg.Strategy)
+	}
+	if cfg.MaxFails <= 0 {
+		cfg.MaxFails = defaultMaxFails
+	}
+	if cfg.EjectDuration <= 0 {
+		cfg.EjectDuration = defaultEjectDuration
+	}
+	if cfg.HealthCheckInterval <= 0 {
+		cfg.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if cfg.HealthCheckTimeout <= 0 {
+		cfg.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+
+	p := &Pool{
+		cfg:  cfg,
+		dial: (&net.Dialer{}).DialContext,
+		done: make(chan struct{}),
+	}
+	for _, raw := range upstreams {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: invalid upstream %q: %w", raw, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("proxy: unsupported upstream scheme %q", u.Scheme)
+		}
+		upstream := &Upstream{URL: u}
+		upstream.healthy.Store(true)
+		p.upstreams = append(p.upstreams, upstream)
+	}
+
+	for _, u := range p.upstreams {
+		for i := 0; i < virtualNodes; i++ {
+			p.ring = append(p.ring, ringNode{hash: hashKey(u.URL.String() + "#" + strconv.Itoa(i)), upstream: u})
+		}
+	}
+	slices.SortFunc(p.ring, func(a, b ringNode) int {
+		if a.hash < b.hash {
+			return -1
+		} else if a.hash > b.hash {
+			return 1
+		}
+		return 0
+	})
+	return p, nil
+}
+
+// Upstreams returns the members of the pool
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// Pick selects an available upstream for the request
+func (p *Pool) Pick(req *request.Request) (*Upstream, error) {
+	now := time.Now()
+	switch p.cfg.Strategy {
+	case LeastConnections:
+		return p.pickLeastConnections(now)
+	case ConsistentHash:
+		return p.pickConsistentHash(req, now)
+	default:
+		return p.pickRoundRobin(now)
+	}
+}
+
+func (p *Pool) pickRoundRobin(now time.Time) (*Upstream, error) {
+	start := p.next.Add(1)
+	for i := range uint64(len(p.upstreams)) {
+		u := p.upstreams[(start+i)%uint64(len(p.upstreams))]
+		if u.available(now) {
+			return u, nil
+		}
+	}
+	return nil, ErrNoHealthyUpstream
+}
+
+func (p *Pool) pickLeastConnections(now time.Time) (*Upstream, error) {
+	// start from a rotating offset so ties are spread evenly
+	start := p.next.Add(1)
+	var best *Upstream
+	for i := range uint64(len(p.upstreams)) {
+		u := p.upstreams[(start+i)%uint64(len(p.upstreams))]
+		if !u.available(now) {
+			continue
+		}
+		if best == nil || u.active.Load() < best.active.Load() {
+			best = u
+		}
+	}
+	if best == nil {
+		return nil, ErrNoHealthyUpstream
+	}
+	return best, nil
+}
+
+func (p *Pool) pickConsistentHash(req *request.Request, now time.Time) (*Upstream, error) {
+	key := ""
+	if p.cfg.HashHeader != "" && req.Headers != nil {
+		key = req.Headers.Get(p.cfg.HashHeader)
+	}
+	if key == "" {
+		key = clientIP(req)
+	}
+
+	h := hashKey(key)
+	i, _ := slices.BinarySearchFunc(p.ring, h, func(n ringNode, h uint64) int {
+		if n.hash < h {
+			return -1
+		} else if n.hash > h {
+			return 1
+		}
+		return 0
+	})
+	// walk the ring clockwise until an available upstream is found
+	for j := range len(p.ring) {
+		u := p.ring[(i+j)%len(p.ring)].upstream
+		if u.available(now) {
+			return u, nil
+		}
+	}
+	return nil, ErrNoHealthyUpstream
+}
+
+// acquire marks the start of a request to the upstream
+func (p *Pool) acquire(u *Upstream) {
+	u.active.Add(1)
+	u.requests.Add(1)
+}
+
+// release marks the end of a request to the upstream and records its outcome
+// for passive health checking
+func (p *Pool) release(u *Upstream, failed bool) {
+	u.active.Add(-1)
+	if !failed {
+		u.consecutive.Store(0)
+		return
+	}
+
+	u.failures.Add(1)
+	if u.consecutive.Add(1) >= int64(p.cfg.MaxFails) {
+		u.consecutive.Store(0)
+		u.ejectedUntil.Store(time.Now().Add(p.cfg.EjectDuration).UnixNano())
+		slog.Warn("upstream ejected", slog.String("upstream", u.URL.Host), slog.Duration("duration", p.cfg.EjectDuration))
+	}
+}
+
+// Start runs the active health checks in the background until Close is called
+func (p *Pool) Start() {
+	if p.cfg.HealthCheckPath == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			p.checkAll()
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.check(u)
+			healthy := err == nil
+			if u.healthy.Swap(healthy) != healthy {
+				slog.Info("upstream health changed", slog.String("upstream", u.URL.Host), slog.Bool("healthy", healthy), slog.Any("err", err))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) check(u *Upstream) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.HealthCheckTimeout)
+	defer cancel()
+	conn, err := dialUpstream(ctx, p.dial, u.URL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.cfg.HealthCheckTimeout))
+
+	h := headers.NewHeaders()
+	h.Replace("Host", u.URL.Host)
+	h.Replace("Connection", "close")
+	req := &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: upstreamTarget(u.URL, p.cfg.HealthCheckPath),
+			Method:        "GET",
+		},
+		Headers: h,
+	}
+	if _, err := req.WriteTo(conn); err != nil {
+		return err
+	}
+	res, err := readResponse(bufio.NewReader(conn), "GET")
+	if err != nil {
+		return err
+	}
+	if code := res.StatusLine.StatusCode; code < 200 || code >= 400 {
+		return fmt.Errorf("proxy: health check returned status %d", code)
+	}
+	return nil
+}
+
+// States returns a snapshot of every upstream
+func (p *Pool) States() []UpstreamState {
+	now := time.Now()
+	states := make([]UpstreamState, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		state := UpstreamState{
+			URL:                 u.URL.String(),
+			Healthy:             u.healthy.Load(),
+			ActiveConnections:   u.active.Load(),
+			Requests:            u.requests.Load(),
+			Failures:            u.failures.Load(),
+			ConsecutiveFailures: u.consecutive.Load(),
+		}
+		if until := time.Unix(0, u.ejectedUntil.Load()); until.After(now) {
+			state.Ejected = true
+			state.EjectedUntil = &until
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// AdminHandler is a server.Handler describing the upstreams as JSON
+func (p *Pool) AdminHandler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		server.NewHandlerError(response.MethodNotAllowed, "only GET is supported").WriteTo(w)
+		return
+	}
+
+	body, err := json.Marshal(p.States())
+	if err != nil {
+		server.NewHandlerError(response.InternalServerError, err.Error()).WriteTo(w)
+		return
+	}
+
+	h := headers.NewHeaders()
+	h.Replace("Content-Type", "application/json")
+	h.Replace("Content-Length", strconv.Itoa(len(body)))
+	h.Replace("Connection", "close")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method == "GET" {
+		w.WriteBody(body)
+	}
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
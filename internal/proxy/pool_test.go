@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var poolUpstreams = []string{"http://10.0.0.1", "http://10.0.0.2", "http://10.0.0.3"}
+
+func newTestRequest(remoteAddr string, kv ...string) *request.Request {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     h,
+		RemoteAddr:  remoteAddr,
+	}
+}
+
+func pickHost(t *testing.T, p *Pool, req *request.Request) string {
+	t.Helper()
+	u, err := p.Pick(req)
+	require.NoError(t, err)
+	return u.URL.Host
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	p, err := NewPool(poolUpstreams, PoolConfig{})
+	require.NoError(t, err)
+
+	req := newTestRequest("127.0.0.1:1234")
+	var picked []string
+	for range 6 {
+		picked = append(picked, pickHost(t, p, req))
+	}
+	assert.Equal(t, picked[:3], picked[3:])
+	assert.ElementsMatch(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, picked[:3])
+
+	// an unhealthy upstream is skipped
+	p.upstreams[1].healthy.Store(false)
+	for range 6 {
+		assert.NotEqual(t, "10.0.0.2", pickHost(t, p, req))
+	}
+
+	for _, u := range p.upstreams {
+		u.healthy.Store(false)
+	}
+	_, err = p.Pick(req)
+	assert.ErrorIs(t, err, ErrNoHealthyUpstream)
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	p, err := NewPool(poolUpstreams, PoolConfig{Strategy: LeastConnections})
+	require.NoError(t, err)
+
+	p.upstreams[0].active.Store(5)
+	p.upstreams[1].active.Store(1)
+	p.upstreams[2].active.Store(3)
+	req := newTestRequest("127.0.0.1:1234")
+	for range 3 {
+		assert.Equal(t, "10.0.0.2", pickHost(t, p, req))
+	}
+
+	p.upstreams[1].ejectedUntil.Store(time.Now().Add(time.Hour).UnixNano())
+	assert.Equal(t, "10.0.0.3", pickHost(t, p, req))
+}
+
+func TestPoolConsistentHash(t *testing.T) {
+	p, err := NewPool(poolUpstreams, PoolConfig{Strategy: ConsistentHash, HashHeader: "X-User"})
+	require.NoError(t, err)
+
+	// the same key always lands on the same upstream
+	counts := map[string]int{}
+	for i := range 300 {
+		req := newTestRequest("127.0.0.1:1234", "X-User", fmt.Sprintf("user-%d", i))
+		host := pickHost(t, p, req)
+		assert.Equal(t, host, pickHost(t, p, req))
+		counts[host]++
+	}
+	require.Len(t, counts, 3)
+	for host, n := range counts {
+		assert.Greater(t, n, 50, host)
+	}
+
+	// the client IP is used when the header is missing
+	byIP := pickHost(t, p, newTestRequest("192.168.1.7:1111"))
+	assert.Equal(t, byIP, pickHost(t, p, newTestRequest("192.168.1.7:2222")))
+
+	// only the keys of an unavailable upstream move
+	req := newTestRequest("127.0.0.1:1234", "X-User", "user-1")
+	before := pickHost(t, p, req)
+	for _, u := range p.upstreams {
+		if u.URL.Host == before {
+			u.healthy.Store(false)
+		}
+	}
+	assert.NotEqual(t, before, pickHost(t, p, req))
+}
+
+func TestPoolPassiveEjection(t *testing.T) {
+	p, err := NewPool(poolUpstreams[:1], PoolConfig{MaxFails: 2, EjectDuration: 50 * time.Millisecond})
+	require.NoError(t, err)
+	u := p.upstreams[0]
+	req := newTestRequest("127.0.0.1:1234")
+
+	p.acquire(u)
+	p.release(u, true)
+	p.acquire(u)
+	p.release(u, false)
+	p.acquire(u)
+	p.release(u, true)
+	_, err = p.Pick(req)
+	require.NoError(t, err, "failures must be consecutive")
+
+	p.acquire(u)
+	p.release(u, true)
+	_, err = p.Pick(req)
+	assert.ErrorIs(t, err, ErrNoHealthyUpstream)
+	states := p.States()
+	assert.True(t, states[0].Ejected)
+	assert.Equal(t, int64(3), states[0].Failures)
+	assert.Equal(t, int64(4), states[0].Requests)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = p.Pick(req)
+	assert.NoError(t, err)
+}
+
+func TestPoolActiveHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		status := response.OK
+		if req.RequestLine.RequestTarget != "/healthz" || !healthy.Load() {
+			status = response.ServiceUnavailable
+		}
+		h := response.GetDefaultHeaders(0)
+		w.WriteStatusLine(status)
+		w.WriteHeaders(h)
+	})
+
+	p, err := NewPool([]string{localURL(upstream)}, PoolConfig{
+		HealthCheckPath:     "/healthz",
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	p.Start()
+	defer p.Close()
+
+	u := p.upstreams[0]
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, u.healthy.Load())
+
+	healthy.Store(false)
+	assert.Eventually(t, func() bool { return !u.healthy.Load() }, time.Second, 10*time.Millisecond)
+
+	healthy.Store(true)
+	assert.Eventually(t, func() bool { return u.healthy.Load() }, time.Second, 10*time.Millisecond)
+}
+
+func TestPoolAdminHandler(t *testing.T) {
+	p, err := NewPool(poolUpstreams[:2], PoolConfig{})
+	require.NoError(t, err)
+	p.upstreams[1].healthy.Store(false)
+	p.upstreams[0].requests.Store(7)
+
+	admin := startServer(t, p.AdminHandler)
+	res, body := roundTrip(t, admin, "GET", "GET /admin/upstreams HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "application/json", res.Headers.Get("Content-Type"))
+
+	var states []UpstreamState
+	require.NoError(t, json.Unmarshal([]byte(body), &states))
+	require.Len(t, states, 2)
+	assert.Equal(t, "http://10.0.0.1", states[0].URL)
+	assert.True(t, states[0].Healthy)
+	assert.Equal(t, int64(7), states[0].Requests)
+	assert.False(t, states[1].Healthy)
+}
+
+func TestReverseProxyBalancing(t *testing.T) {
+	var hits [2]atomic.Int64
+	var upstreams []string
+	for i := range hits {
+		s := startServer(t, func(w *response.Writer, req *request.Request) {
+			hits[i].Add(1)
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		})
+		upstreams = append(upstreams, localURL(s))
+	}
+	// a dead upstream is ejected after a single failure
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	upstreams = append(upstreams, "http://"+l.Addr().String())
+	l.Close()
+
+	p := startProxy(t, ReverseProxyConfig{
+		Upstreams: upstreams,
+		Pool:      PoolConfig{MaxFails: 1},
+	})
+	statuses := []string{}
+	for range 7 {
+		res, _ := roundTrip(t, p, "GET", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		statuses = append(statuses, fmt.Sprint(int(res.StatusLine.StatusCode)))
+	}
+	assert.Equal(t, 1, strings.Count(strings.Join(statuses, ","), "502"), statuses)
+	assert.Equal(t, int64(3), hits[0].Load())
+	assert.Equal(t, int64(3), hits[1].Load())
+}
@@ -0,0 +1,371 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+const defaultResponseHeaderTimeout = 30 * time.Second
+
+var (
+	ErrNoUpstream = fmt.Errorf("proxy: no upstream configured")
+)
+
+// hopHeaders are meaningful only for a single connection and must not be
+// forwarded by proxies, see RFC 9110 section 7.6.1
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxyConfig configures ReverseProxy. Responses are streamed from the
+// upstream to the client, request bodies are not: the server reads them
+// entirely before the handler runs, within request.Limits.MaxBodyBytes, and
+// they are sent to the upstream from memory
+type ReverseProxyConfig struct {
+	// Upstreams are the base URLs requests are forwarded to, e.g.
+	// http://127.0.0.1:8080/api
+	Upstreams []string
+	// Pool configures how requests are spread over the upstreams and how
+	// their health is checked
+	Pool PoolConfig
+	// StripPrefix is removed from the request target before it is appended to
+	// the upstream path
+	StripPrefix string
+
+	// DialTimeout bounds the time spent connecting to an upstream
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds the time spent waiting for the upstream
+	// response head once the request has been sent
+	ResponseHeaderTimeout time.Duration
+
+	// Dial connects to the upstream, net.Dialer is used when nil
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// ReverseProxy forwards requests to upstream servers and streams their
+// responses back to the client
+type ReverseProxy struct {
+	cfg  ReverseProxyConfig
+	pool *Pool
+}
+
+// NewReverseProxy creates the proxy and starts the health checks of its pool,
+// Close stops them
+func NewReverseProxy(cfg ReverseProxyConfig) (*ReverseProxy, error) {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.ResponseHeaderTimeout <= 0 {
+		cfg.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = (&net.Dialer{}).DialContext
+	}
+
+	pool, err := NewPool(cfg.Upstreams, cfg.Pool)
+	if err != nil {
+		return nil, err
+	}
+	pool.dial = cfg.Dial
+	pool.Start()
+	return &ReverseProxy{cfg: cfg, pool: pool}, nil
+}
+
+// Pool returns the upstreams pool, e.g. to expose its admin handler
+func (p *ReverseProxy) Pool() *Pool {
+	return p.pool
+}
+
+func (p *ReverseProxy) Close() {
+	p.pool.Close()
+}
+
+// Handle is a server.Handler forwarding the request to an upstream
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	u, err := p.pool.Pick(req)
+	if err != nil {
+		slog.Error("no upstream available", slog.Any("err", err))
+		server.NewHandlerError(response.ServiceUnavailable, response.StatusText(response.ServiceUnavailable)).WriteTo(w)
+		return
+	}
+
+	p.pool.acquire(u)
+	failed := false
+	defer func() {
+		p.pool.release(u, failed)
+	}()
+
+	res, conn, err := p.roundTrip(u.URL, req)
+	if err != nil {
+		failed = true
+		p.writeError(w, u.URL, err)
+		return
+	}
+	defer conn.Close()
+	failed = res.StatusLine.StatusCode >= 500
+
+	if err := copyResponse(w, res, req.RequestLine.Method); err != nil {
+		// the status line is already sent, the connection will be closed by
+		// the server which signals the client the response is incomplete
+		slog.Error("failed to copy upstream response", slog.String("upstream", u.URL.Host), slog.Any("err", err))
+	}
+}
+
+// roundTrip sends the request to the upstream and reads the response head, the
+// caller must close the returned connection once the body is consumed
+func (p *ReverseProxy) roundTrip(upstream *url.URL, req *request.Request) (*response.Response, net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DialTimeout)
+	conn, err := dialUpstream(ctx, p.cfg.Dial, upstream)
+	cancel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			RequestTarget: upstreamTarget(upstream, strings.TrimPrefix(req.RequestLine.RequestTarget, p.cfg.StripPrefix)),
+			Method:        req.RequestLine.Method,
+		},
+		Headers: outgoingHeaders(req, upstream),
+		Body:    req.Body,
+	}
+	conn.SetDeadline(time.Now().Add(p.cfg.ResponseHeaderTimeout))
+	if _, err := out.WriteTo(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	res, err := readResponse(bufio.NewReader(conn), req.RequestLine.Method)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return res, conn, nil
+}
+
+// dialUpstream connects to the upstream and performs the TLS handshake for
+// https upstreams
+func dialUpstream(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), upstream *url.URL) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", upstreamAddr(upstream))
+	if err != nil {
+		return nil, err
+	}
+	if upstream.Scheme != "https" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: upstream.Hostname()})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// writeError maps upstream failures to 504 for timeouts and 502 otherwise
+func (p *ReverseProxy) writeError(w *response.Writer, upstream *url.URL, err error) {
+	slog.Error("upstream request failed", slog.String("upstream", upstream.Host), slog.Any("err", err))
+	status := response.BadGateway
+	if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+		status = response.GatewayTimeout
+	}
+	server.NewHandlerError(status, response.StatusText(status)).WriteTo(w)
+}
+
+func upstreamAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// upstreamTarget joins the upstream base path with the request target
+func upstreamTarget(upstream *url.URL, target string) string {
+	path, query, hasQuery := strings.Cut(target, "?")
+	base := strings.TrimSuffix(upstream.EscapedPath(), "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	path = base + path
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
+
+// outgoingHeaders copies the end-to-end request headers and adds the
+// forwarding headers describing the original request
+func outgoingHeaders(req *request.Request, upstream *url.URL) *headers.Headers {
+	h := headers.NewHeaders()
+	if req.Headers != nil {
+		h = req.Headers.Clone()
+	}
+	removeHopHeaders(h)
+	// the request body is buffered, see ReverseProxyConfig, there is nothing
+	// left for the upstream to continue
+	h.Delete("Expect")
+
+	host := h.Get("Host")
+	clientIP := clientIP(req)
+
+	if clientIP != "" {
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if host != "" {
+		h.Replace("X-Forwarded-Host", host)
+	}
+	h.Replace("X-Forwarded-Proto", "http")
+	h.Set("Forwarded", forwardedElement(clientIP, host))
+
+	h.Replace("Host", upstream.Host)
+	h.Replace("Connection", "close")
+	return h
+}
+
+// clientIP returns the IP of the client which sent the request
+func clientIP(req *request.Request) string {
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return ip
+	}
+	return req.RemoteAddr
+}
+
+// forwardedElement builds a Forwarded header element, see RFC 7239
+func forwardedElement(clientIP, host string) string {
+	var parts []string
+	if clientIP != "" {
+		if strings.Contains(clientIP, ":") {
+			// IPv6 addresses must be bracketed and quoted
+			parts = append(parts, fmt.Sprintf("for=\"[%s]\"", clientIP))
+		} else {
+			parts = append(parts, "for="+clientIP)
+		}
+	}
+	if host != "" {
+		parts = append(parts, "host="+quoteIfNeeded(host))
+	}
+	parts = append(parts, "proto=http")
+	return strings.Join(parts, ";")
+}
+
+func quoteIfNeeded(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// removeHopHeaders deletes the hop-by-hop headers, including the ones listed
+// in the Connection header
+func removeHopHeaders(h *headers.Headers) {
+	for _, name := range strings.Split(h.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Delete(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Delete(name)
+	}
+}
+
+// readResponse reads the final response from the upstream, interim responses
+// preceding it are skipped
+func readResponse(br *bufio.Reader, method string) (*response.Response, error) {
+	for {
+		res, err := response.ResponseFromReader(br, method)
+		if err != nil {
+			return nil, err
+		}
+		code := res.StatusLine.StatusCode
+		if code >= 100 && code < 200 && code != response.SwitchingProtocols {
+			continue
+		}
+		return res, nil
+	}
+}
+
+// copyResponse streams the upstream response to the client, keeping the
+// upstream framing when the length is known and using chunked encoding
+// otherwise
+func copyResponse(w *response.Writer, res *response.Response, method string) error {
+	h := res.Headers
+	removeHopHeaders(h)
+	h.Replace("Connection", "close")
+
+	hasBody := response.BodyAllowed(method, res.StatusLine.StatusCode)
+	chunked := hasBody && res.ContentLength < 0
+	if chunked {
+		h.Replace("Transfer-Encoding", "chunked")
+	}
+
+	if _, err := w.WriteStatusLine(res.StatusLine.StatusCode); err != nil {
+		return err
+	}
+	if _, err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if !hasBody {
+		return nil
+	}
+
+	if !chunked {
+		_, err := io.Copy(w, res.Body)
+		return err
+	}
+
+	p := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(p)
+		if n > 0 {
+			if _, werr := w.WriteChunkedBody(p[:n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	trailers := headers.NewHeaders()
+	if res.Trailers != nil {
+		trailers = res.Trailers
+	}
+	_, err := w.WriteTrailers(trailers)
+	return err
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, h server.Handler) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, h)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func startProxy(t *testing.T, cfg ReverseProxyConfig) *server.Server {
+	t.Helper()
+	p, err := NewReverseProxy(cfg)
+	require.NoError(t, err)
+	t.Cleanup(p.Close)
+	return startServer(t, p.Handle)
+}
+
+func localAddr(s *server.Server) string {
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	return "127.0.0.1:" + port
+}
+
+func localURL(s *server.Server) string {
+	return "http://" + localAddr(s)
+}
+
+// roundTrip sends a raw request and reads the response until the server closes
+// the connection
+func roundTrip(t *testing.T, s *server.Server, method, raw string) (*response.Response, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", localAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	res, err := readResponse(bufio.NewReader(conn), method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		body := fmt.Sprintf("%s %s\nhost=%s\nxff=%s\nxfh=%s\nforwarded=%s\nkeep-alive=%s\nbody=%s",
+			req.RequestLine.Method, req.RequestLine.RequestTarget,
+			req.Headers.Get("Host"), req.Headers.Get("X-Forwarded-For"), req.Headers.Get("X-Forwarded-Host"),
+			req.Headers.Get("Forwarded"), req.Headers.Get("Keep-Alive"), req.Body)
+		h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
+		h.Replace("X-Upstream", "yes")
+		h.Replace("Keep-Alive", "timeout=5")
+		w.WriteStatusLine(response.Created)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	})
+	p := startProxy(t, ReverseProxyConfig{
+		Upstreams:   []string{localURL(upstream) + "/api"},
+		StripPrefix: "/proxy",
+	})
+
+	res, body := roundTrip(t, p, "POST", "POST /proxy/users?id=1 HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\n"+
+		"Connection: keep-alive\r\n"+
+		"Keep-Alive: timeout=5\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+
+	assert.Equal(t, response.Created, res.StatusLine.StatusCode)
+	assert.Equal(t, "yes", res.Headers.Get("X-Upstream"))
+	assert.Equal(t, "", res.Headers.Get("Keep-Alive"))
+	assert.Equal(t, strings.Join([]string{
+		"POST /api/users?id=1",
+		"host=" + strings.TrimPrefix(localURL(upstream), "http://"),
+		"xff=10.0.0.1, 127.0.0.1",
+		"xfh=example.com",
+		"forwarded=for=127.0.0.1;host=example.com;proto=http",
+		"keep-alive=",
+		"body=hello",
+	}, "\n"), body)
+}
+
+func TestReverseProxyCookies(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := strings.Join(req.Headers.Values("Cookie"), "|")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	p := startProxy(t, ReverseProxyConfig{Upstreams: []string{localURL(upstream)}})
+
+	res, body := roundTrip(t, p, "GET", "GET / HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Cookie: a=1\r\n"+
+		"Cookie: b=2\r\n"+
+		"\r\n")
+
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "a=1|b=2", body)
+}
+
+func TestReverseProxyChunkedUpstream(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Replace("Transfer-Encoding", "chunked")
+		h.Replace("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.Write([]byte("0\r\n"))
+		trailers := headers.NewHeaders()
+		trailers.Replace("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	})
+	p := startProxy(t, ReverseProxyConfig{Upstreams: []string{localURL(upstream)}})
+
+	res, body := roundTrip(t, p, "GET", "GET /stream HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "chunked", res.Headers.Get("Transfer-Encoding"))
+	assert.Equal(t, "hello world", body)
+	assert.Equal(t, "abc", res.Trailers.Get("X-Checksum"))
+}
+
+func TestReverseProxyHead(t *testing.T) {
+	upstream := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Replace("Content-Length", "42")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+	})
+	p := startProxy(t, ReverseProxyConfig{Upstreams: []string{localURL(upstream)}})
+
+	res, body := roundTrip(t, p, "HEAD", "HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.OK, res.StatusLine.StatusCode)
+	assert.Equal(t, "42", res.Headers.Get("Content-Length"))
+	assert.Equal(t, "", body)
+}
+
+func TestReverseProxyUpstreamErrors(t *testing.T) {
+	// nothing listens on a closed listener's port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	p := startProxy(t, ReverseProxyConfig{Upstreams: []string{"http://" + closedAddr}})
+	res, _ := roundTrip(t, p, "GET", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.BadGateway, res.StatusLine.StatusCode)
+
+	// an upstream accepting connections but never answering
+	hang, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer hang.Close()
+	go func() {
+		for {
+			conn, err := hang.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	p = startProxy(t, ReverseProxyConfig{
+		Upstreams:             []string{"http://" + hang.Addr().String()},
+		ResponseHeaderTimeout: 50 * time.Millisecond,
+	})
+	res, _ = roundTrip(t, p, "GET", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.Equal(t, response.GatewayTimeout, res.StatusLine.StatusCode)
+}
+
+func TestUpstreamTarget(t *testing.T) {
+	tests := []struct {
+		base   string
+		target string
+		expect string
+	}{
+		{base: "http://a", target: "/", expect: "/"},
+		{base: "http://a/", target: "/x", expect: "/x"},
+		{base: "http://a/api", target: "/x?y=1", expect: "/api/x?y=1"},
+		{base: "http://a/api/", target: "", expect: "/api/"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.base)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expect, upstreamTarget(u, tt.target), tt.base+tt.target)
+	}
+}
@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+const (
+	defaultDialTimeout       = 10 * time.Second
+	defaultTunnelIdleTimeout = 5 * time.Minute
+	// minIdleCheckInterval keeps tiny idle timeouts from stopping the ticker
+	minIdleCheckInterval = time.Millisecond
+)
+
+var errDestinationDenied = errors.New("proxy: destination is not allowed")
+
+type TunnelConfig struct {
+	// DialTimeout bounds the time spent connecting to the destination
+	DialTimeout time.Duration
+	// IdleTimeout closes the tunnel when no byte has flowed in either
+	// direction for that long
+	IdleTimeout time.Duration
+
+	// Allow and Deny restrict the destinations, see Rule for the format. A
+	// destination must match an Allow rule and no Deny rule, every
+	// destination is denied when Allow is empty. Host names are resolved and
+	// IP rules checked against each address, only addresses passing the
+	// rules are dialed
+	Allow []Rule
+	Deny  []Rule
+
+	// Resolve looks up the addresses of a host name, net.DefaultResolver is
+	// used when nil
+	Resolve func(ctx context.Context, host string) ([]netip.Addr, error)
+	// Dial connects to the destination, net.Dialer is used when nil. It is
+	// given an IP address and a port
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Tunnel handles CONNECT requests by splicing the client connection with a
+// TCP connection to the requested destination
+type Tunnel struct {
+	cfg TunnelConfig
+}
+
+func NewTunnel(cfg TunnelConfig) *Tunnel {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultDialTimeout
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = defaultTunnelIdleTimeout
+	}
+	if cfg.Resolve == nil {
+		cfg.Resolve = func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		}
+	}
+	if cfg.Dial == nil {
+		cfg.Dial = (&net.Dialer{}).DialContext
+	}
+	return &Tunnel{cfg: cfg}
+}
+
+// Handle is a server.Handler for CONNECT requests
+func (t *Tunnel) Handle(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "CONNECT" {
+		server.NewHandlerError(response.MethodNotAllowed, "only CONNECT is supported").WriteTo(w)
+		return
+	}
+
+	target := req.RequestLine.RequestTarget
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		server.NewHandlerError(response.BadRequest, "invalid CONNECT target").WriteTo(w)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.cfg.DialTimeout)
+	upstream, err := t.dial(ctx, host, port)
+	cancel()
+	if errors.Is(err, errDestinationDenied) {
+		slog.Info("tunnel rejected", slog.String("target", target))
+		server.NewHandlerError(response.Forbidden, "destination is not allowed").WriteTo(w)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to dial tunnel destination", slog.String("target", target), slog.Any("err", err))
+		status := response.BadGateway
+		if errors.Is(err, context.DeadlineExceeded) || isTimeout(err) {
+			status = response.GatewayTimeout
+		}
+		server.NewHandlerError(status, "failed to connect to destination").WriteTo(w)
+		return
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		slog.Error("failed to hijack connection", slog.Any("err", err))
+		server.NewHandlerError(response.InternalServerError, "failed to establish tunnel").WriteTo(w)
+		return
+	}
+
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err == nil && len(buffered) > 0 {
+		_, err = upstream.Write(buffered)
+	}
+	if err != nil {
+		conn.Close()
+		upstream.Close()
+		slog.Error("failed to establish tunnel", slog.String("target", target), slog.Any("err", err))
+		return
+	}
+
+	start := time.Now()
+	up, down := t.splice(conn, upstream)
+	slog.Info("tunnel closed",
+		slog.String("client", conn.RemoteAddr().String()),
+		slog.String("target", target),
+		slog.Int64("bytes_up", up+int64(len(buffered))),
+		slog.Int64("bytes_down", down),
+		slog.Duration("duration", time.Since(start)),
+	)
+}
+
+// splice copies bytes in both directions until both sides are done or the
+// tunnel is idle for too long. It returns the number of bytes sent from client
+// to upstream and from upstream to client
+func (t *Tunnel) splice(client, upstream net.Conn) (int64, int64) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var up, down int64
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		up = pipe(upstream, client, &lastActive)
+	}()
+	go func() {
+		defer wg.Done()
+		down = pipe(client, upstream, &lastActive)
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(max(t.cfg.IdleTimeout/4, minIdleCheckInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			client.Close()
+			upstream.Close()
+			return up, down
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, lastActive.Load()))
+			if idle >= t.cfg.IdleTimeout {
+				// unblock both copies, they return once the connections are closed
+				client.Close()
+				upstream.Close()
+			}
+		}
+	}
+}
+
+// pipe copies from src to dst and half-closes dst once src is drained so the
+// other direction can keep flowing
+func pipe(dst, src net.Conn, lastActive *atomic.Int64) int64 {
+	n, _ := io.Copy(dst, &activityReader{r: src, lastActive: lastActive})
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	return n
+}
+
+type activityReader struct {
+	r          io.Reader
+	lastActive *atomic.Int64
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// dial connects to the first address of the host passing the rules. The
+// host name is checked before it is resolved, then each of its addresses, so
+// that the address dialed is the one checked
+func (t *Tunnel) dial(ctx context.Context, host, port string) (net.Conn, error) {
+	host = normalizeHost(host)
+	if t.denied(host, port) {
+		return nil, errDestinationDenied
+	}
+	nameAllowed := t.allowed(host, port)
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if addrs, err = t.cfg.Resolve(ctx, host); err != nil {
+		return nil, err
+	}
+
+	var err error = errDestinationDenied
+	for _, addr := range addrs {
+		ip := addr.Unmap().WithZone("").String()
+		if t.denied(ip, port) || !nameAllowed && !t.allowed(ip, port) {
+			continue
+		}
+		var conn net.Conn
+		if conn, err = t.cfg.Dial(ctx, "tcp", net.JoinHostPort(addr.Unmap().String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// denied reports whether the host matches a Deny rule
+func (t *Tunnel) denied(host, port string) bool {
+	for _, r := range t.cfg.Deny {
+		if r.Match(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the host matches an Allow rule
+func (t *Tunnel) allowed(host, port string) bool {
+	for _, r := range t.cfg.Allow {
+		if r.Match(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// Rule matches tunnel destinations. It is written as host[:port] where host is
+// either an exact host name, an IP, a CIDR prefix as in 10.0.0.0/8, "*" for
+// any host or "*.example.com" for any subdomain of example.com, and port is a
+// port number or "*". A rule without port matches any port. Name rules match
+// host names and IP rules addresses, IPv4-mapped IPv6 addresses match as IPv4
+// ones and trailing dots are ignored
+type Rule struct {
+	Host string
+	Port string
+}
+
+func ParseRule(s string) Rule {
+	s = strings.TrimSpace(s)
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Rule{Host: s, Port: "*"}
+	}
+	return Rule{Host: host, Port: port}
+}
+
+// ParseRules parses a comma separated list of rules
+func ParseRules(s string) []Rule {
+	var rules []Rule
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		rules = append(rules, ParseRule(part))
+	}
+	return rules
+}
+
+func (r Rule) Match(host, port string) bool {
+	if r.Port != "*" && r.Port != "" && r.Port != port {
+		return false
+	}
+	if r.Host == "*" {
+		return true
+	}
+	host, ruleHost := normalizeHost(host), normalizeHost(r.Host)
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap().WithZone("")
+		if prefix, err := netip.ParsePrefix(ruleHost); err == nil {
+			return prefix.Contains(addr)
+		}
+		ruleAddr, err := netip.ParseAddr(ruleHost)
+		return err == nil && ruleAddr.Unmap().WithZone("") == addr
+	}
+	if suffix, ok := strings.CutPrefix(ruleHost, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return ruleHost == host
+}
+
+// normalizeHost lowers the host and strips the trailing dot of fully
+// qualified names, example.com. is example.com
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
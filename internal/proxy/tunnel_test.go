@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEcho starts a TCP server echoing back everything it receives
+func startEcho(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func startTunnel(t *testing.T, cfg TunnelConfig) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, NewTunnel(cfg).Handle)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func connect(t *testing.T, s *server.Server, target string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	statusLine, err := br.ReadString('\n')
+	require.NoError(t, err)
+	return conn, br, statusLine
+}
+
+func TestTunnel(t *testing.T) {
+	echo := startEcho(t)
+	s := startTunnel(t, TunnelConfig{Allow: ParseRules("127.0.0.1")})
+
+	conn, br, statusLine := connect(t, s, echo.Addr().String())
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	for _, msg := range []string{"hello", "world"} {
+		_, err = conn.Write([]byte(msg))
+		require.NoError(t, err)
+		p := make([]byte, len(msg))
+		_, err = io.ReadFull(br, p)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(p))
+	}
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	echo := startEcho(t)
+	s := startTunnel(t, TunnelConfig{Allow: ParseRules("127.0.0.1"), IdleTimeout: 100 * time.Millisecond})
+
+	conn, br, statusLine := connect(t, s, echo.Addr().String())
+	require.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+	_, err := br.ReadString('\n')
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestTunnelDenied(t *testing.T) {
+	echo := startEcho(t)
+	_, port, err := net.SplitHostPort(echo.Addr().String())
+	require.NoError(t, err)
+
+	s := startTunnel(t, TunnelConfig{Deny: ParseRules("127.0.0.1:" + port)})
+	_, _, statusLine := connect(t, s, echo.Addr().String())
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+
+	s = startTunnel(t, TunnelConfig{Allow: ParseRules("*.example.com:443")})
+	_, _, statusLine = connect(t, s, echo.Addr().String())
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+
+	// nothing is allowed by default
+	s = startTunnel(t, TunnelConfig{})
+	_, _, statusLine = connect(t, s, echo.Addr().String())
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine)
+}
+
+func TestTunnelDeniedAddresses(t *testing.T) {
+	echo := startEcho(t)
+	_, port, err := net.SplitHostPort(echo.Addr().String())
+	require.NoError(t, err)
+
+	// every name resolves to loopback
+	resolve := func(ctx context.Context, host string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("::ffff:127.0.0.1")}, nil
+	}
+	s := startTunnel(t, TunnelConfig{
+		Allow:   ParseRules("*"),
+		Deny:    ParseRules("127.0.0.1, *.internal.example.com"),
+		Resolve: resolve,
+	})
+	for _, host := range []string{"localhost", "127.1", "2130706433", "[::ffff:127.0.0.1]", "127.0.0.1.", "db.internal.example.com."} {
+		_, _, statusLine := connect(t, s, host+":"+port)
+		assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", statusLine, host)
+	}
+
+	// the resolved address is dialed, not the name
+	var dialed []string
+	s = startTunnel(t, TunnelConfig{
+		Allow:   ParseRules("localhost"),
+		Resolve: resolve,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	})
+	_, _, statusLine := connect(t, s, "LOCALHOST.:"+port)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+	assert.Equal(t, []string{"127.0.0.1:" + port}, dialed)
+}
+
+func TestTunnelTinyIdleTimeout(t *testing.T) {
+	echo := startEcho(t)
+	s := startTunnel(t, TunnelConfig{Allow: ParseRules("127.0.0.1"), IdleTimeout: 2 * time.Nanosecond})
+
+	conn, br, statusLine := connect(t, s, echo.Addr().String())
+	require.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+	_, err := br.ReadString('\n')
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule   string
+		host   string
+		port   string
+		expect bool
+	}{
+		{rule: "example.com:443", host: "example.com", port: "443", expect: true},
+		{rule: "example.com:443", host: "EXAMPLE.com", port: "443", expect: true},
+		{rule: "example.com:443", host: "example.com", port: "80", expect: false},
+		{rule: "example.com", host: "example.com", port: "8080", expect: true},
+		{rule: "example.com:*", host: "example.com", port: "8080", expect: true},
+		{rule: "*:443", host: "anything.org", port: "443", expect: true},
+		{rule: "*:443", host: "anything.org", port: "22", expect: false},
+		{rule: "*.example.com", host: "api.example.com", port: "443", expect: true},
+		{rule: "*.example.com", host: "example.com", port: "443", expect: false},
+		{rule: "*.example.com", host: "badexample.com", port: "443", expect: false},
+		{rule: "[::1]:22", host: "::1", port: "22", expect: true},
+		{rule: "127.0.0.1", host: "::ffff:127.0.0.1", port: "22", expect: true},
+		{rule: "127.0.0.1", host: "127.0.0.1.", port: "22", expect: true},
+		{rule: "127.0.0.1", host: "localhost", port: "22", expect: false},
+		{rule: "localhost", host: "127.0.0.1", port: "22", expect: false},
+		{rule: "example.com", host: "Example.COM.", port: "443", expect: true},
+		{rule: "*.example.com", host: "api.example.com.", port: "443", expect: true},
+		{rule: "10.0.0.0/8", host: "10.1.2.3", port: "443", expect: true},
+		{rule: "10.0.0.0/8:22", host: "10.1.2.3", port: "443", expect: false},
+		{rule: "10.0.0.0/8", host: "11.1.2.3", port: "443", expect: false},
+		{rule: "fe80::/10", host: "fe80::1%eth0", port: "443", expect: true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expect, ParseRule(tt.rule).Match(tt.host, tt.port), tt.rule+" "+tt.host+":"+tt.port)
+	}
+}
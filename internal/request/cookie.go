@@ -0,0 +1,32 @@
+package request
+
+import (
+	"fmt"
+
+	"github.com/phungducminh/httpfromtcp/internal/cookie"
+)
+
+var ErrNoCookie = fmt.Errorf("request: named cookie not present")
+
+// Cookies returns the cookies of the Cookie header, malformed ones are left
+// out
+func (r *Request) Cookies() []*cookie.Cookie {
+	var cookies []*cookie.Cookie
+	for _, line := range r.Headers.Values("Cookie") {
+		cookies = append(cookies, cookie.Parse(line, "")...)
+	}
+	return cookies
+}
+
+// Cookie returns the first cookie named name
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	if name == "" {
+		return nil, ErrNoCookie
+	}
+	for _, line := range r.Headers.Values("Cookie") {
+		if cookies := cookie.Parse(line, name); len(cookies) > 0 {
+			return cookies[0], nil
+		}
+	}
+	return nil, ErrNoCookie
+}
@@ -0,0 +1,28 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: id=a3fWa; theme=light\r\nCookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+
+	var names []string
+	for _, c := range r.Cookies() {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	assert.Equal(t, []string{"id=a3fWa", "theme=light", "lang=en"}, names)
+
+	c, err := r.Cookie("lang")
+	require.NoError(t, err)
+	assert.Equal(t, "en", c.Value)
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, ErrNoCookie)
+	_, err = r.Cookie("")
+	assert.ErrorIs(t, err, ErrNoCookie)
+}
@@ -0,0 +1,114 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrRequestLineTooLong          = fmt.Errorf("%w: too long", ErrMalformedRequestLine)
+	ErrUnsupportedHTTPVersion      = fmt.Errorf("%w: unsupported http version", ErrMalformedRequestLine)
+	ErrRequestHeadersTooLarge      = fmt.Errorf("%w: too large", ErrMalformedRequestHeaders)
+	ErrUnsupportedTransferEncoding = fmt.Errorf("%w: unsupported transfer-encoding", ErrMalformedRequestHeaders)
+	ErrRequestBodyTooLarge         = fmt.Errorf("%w: too large", ErrMalformedRequestBody)
+)
+
+// Phase is the part of the request being parsed when an error occurs
+type Phase string
+
+const (
+	PhaseRequestLine Phase = "request line"
+	PhaseHeaders     Phase = "headers"
+	PhaseBody        Phase = "body"
+)
+
+// maxSnippetLen bounds the bytes of the request kept in a ParseError
+const maxSnippetLen = 32
+
+// ParseError describes why a request could not be parsed. It wraps one of the
+// package errors, errors.Is works as with the bare error
+type ParseError struct {
+	Phase Phase
+	// Offset is the position of the offending bytes from the start of the
+	// request
+	Offset int
+	// Snippet holds the bytes at Offset, up to the end of their line
+	Snippet string
+	// StatusCode is the status of the response the request deserves: 400,
+	// 413, 414, 431, 501 or 505
+	StatusCode int
+	Err        error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v (%s, byte %d: %q)", e.Err, e.Phase, e.Offset, e.Snippet)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseError creates the error for the bytes at p[off:], its offset is
+// relative to p until the request makes it absolute
+func parseError(phase Phase, err error, p []byte, off int) *ParseError {
+	off = min(max(off, 0), len(p))
+	snippet := p[off:min(off+maxSnippetLen, len(p))]
+	if i := bytes.Index(snippet, ls); i != -1 {
+		snippet = snippet[:i]
+	}
+	return &ParseError{
+		Phase:      phase,
+		Offset:     off,
+		Snippet:    string(snippet),
+		StatusCode: statusFor(err),
+		Err:        err,
+	}
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrRequestLineTooLong):
+		return 414
+	case errors.Is(err, ErrRequestHeadersTooLarge):
+		return 431
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		return 413
+	case errors.Is(err, ErrUnsupportedTransferEncoding):
+		return 501
+	case errors.Is(err, ErrUnsupportedHTTPVersion):
+		return 505
+	default:
+		return 400
+	}
+}
+
+const (
+	DefaultMaxRequestLineBytes = 8 << 10
+	DefaultMaxHeaderBytes      = 64 << 10
+	DefaultMaxBodyBytes        = 10 << 20
+)
+
+// Limits bounds the size of the parts of a request, zero fields take the
+// defaults
+type Limits struct {
+	// MaxRequestLineBytes excludes the CRLF ending the line
+	MaxRequestLineBytes int
+	// MaxHeaderBytes includes the empty line ending the header section
+	MaxHeaderBytes int
+	// MaxBodyBytes applies to the decoded body
+	MaxBodyBytes int64
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes <= 0 {
+		l.MaxRequestLineBytes = DefaultMaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	return l
+}
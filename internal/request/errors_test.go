@@ -0,0 +1,141 @@
+package request
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		description  string
+		data         string
+		limits       Limits
+		expectErr    error
+		expectPhase  Phase
+		expectOffset int
+		expectStatus int
+		expectSnip   string
+	}{
+		{
+			description:  "lowercase method",
+			data:         "get / HTTP/1.1\r\n\r\n",
+			expectErr:    ErrMalformedRequestLine,
+			expectPhase:  PhaseRequestLine,
+			expectOffset: 0,
+			expectStatus: 400,
+			expectSnip:   "get / HTTP/1.1",
+		},
+		{
+			description:  "malformed version",
+			data:         "GET / HTTP1.1\r\n\r\n",
+			expectErr:    ErrMalformedRequestLine,
+			expectPhase:  PhaseRequestLine,
+			expectOffset: 6,
+			expectStatus: 400,
+			expectSnip:   "HTTP1.1",
+		},
+		{
+			description:  "unsupported version",
+			data:         "GET / HTTP/2.0\r\n\r\n",
+			expectErr:    ErrUnsupportedHTTPVersion,
+			expectPhase:  PhaseRequestLine,
+			expectOffset: 6,
+			expectStatus: 505,
+			expectSnip:   "HTTP/2.0",
+		},
+		{
+			description:  "request line too long",
+			data:         "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n",
+			limits:       Limits{MaxRequestLineBytes: 64},
+			expectErr:    ErrRequestLineTooLong,
+			expectPhase:  PhaseRequestLine,
+			expectOffset: 0,
+			expectStatus: 414,
+			expectSnip:   "GET /" + strings.Repeat("a", 27),
+		},
+		{
+			description:  "malformed field line",
+			data:         "GET / HTTP/1.1\r\nHost: localhost\r\nBad Name: x\r\n\r\n",
+			expectErr:    headers.ErrMalformedHeaders,
+			expectPhase:  PhaseHeaders,
+			expectOffset: 33,
+			expectStatus: 400,
+			expectSnip:   "Bad Name: x",
+		},
+		{
+			description:  "headers too large",
+			data:         "GET / HTTP/1.1\r\nX-Large: " + strings.Repeat("b", 100) + "\r\n\r\n",
+			limits:       Limits{MaxHeaderBytes: 64},
+			expectErr:    ErrRequestHeadersTooLarge,
+			expectPhase:  PhaseHeaders,
+			expectOffset: 16,
+			expectStatus: 431,
+			expectSnip:   "X-Large: " + strings.Repeat("b", 23),
+		},
+		{
+			description:  "content-length above the limit",
+			data:         "POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\n",
+			limits:       Limits{MaxBodyBytes: 10},
+			expectErr:    ErrRequestBodyTooLarge,
+			expectPhase:  PhaseBody,
+			expectOffset: 40,
+			expectStatus: 413,
+			expectSnip:   "content-length: 100",
+		},
+		{
+			description:  "chunked body above the limit",
+			data:         "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n10\r\n",
+			limits:       Limits{MaxBodyBytes: 10},
+			expectErr:    ErrRequestBodyTooLarge,
+			expectPhase:  PhaseBody,
+			expectOffset: 57,
+			expectStatus: 413,
+			expectSnip:   "10",
+		},
+		{
+			description:  "unsupported transfer-encoding",
+			data:         "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n",
+			expectErr:    ErrUnsupportedTransferEncoding,
+			expectPhase:  PhaseBody,
+			expectOffset: 44,
+			expectStatus: 501,
+			expectSnip:   "transfer-encoding: gzip",
+		},
+		{
+			description:  "truncated body",
+			data:         "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nhello",
+			expectErr:    ErrMalformedRequestBody,
+			expectPhase:  PhaseBody,
+			expectOffset: 44,
+			expectStatus: 400,
+			expectSnip:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, numBytesPerRead := range []int{1, 7, 1024} {
+			_, err := RequestFromReaderLimits(newChunkReader([]byte(tt.data), numBytesPerRead), tt.limits)
+			require.Error(t, err, tt.description)
+			assert.ErrorIs(t, err, tt.expectErr, tt.description)
+
+			var pe *ParseError
+			require.True(t, errors.As(err, &pe), tt.description)
+			assert.Equal(t, tt.expectPhase, pe.Phase, tt.description)
+			assert.Equal(t, tt.expectOffset, pe.Offset, tt.description)
+			assert.Equal(t, tt.expectStatus, pe.StatusCode, tt.description)
+			assert.Equal(t, tt.expectSnip, pe.Snippet, tt.description)
+		}
+	}
+}
+
+func TestParseErrorSentinels(t *testing.T) {
+	_, err := RequestFromReader(newChunkReader([]byte("GET / HTTP/1.0\r\n\r\n"), 3))
+	assert.ErrorIs(t, err, ErrUnsupportedHTTPVersion)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
+	assert.Contains(t, err.Error(), `request line, byte 6: "HTTP/1.0"`)
+}
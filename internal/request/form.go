@@ -0,0 +1,335 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrNotMultipart  = fmt.Errorf("request: content-type isn't multipart/form-data")
+	ErrMissingFile   = fmt.Errorf("request: no such file in the form")
+	ErrMalformedForm = fmt.Errorf("request: malformed form")
+	ErrTooManyParts  = fmt.Errorf("%w: too many parts", ErrMalformedForm)
+	ErrPartTooLarge  = fmt.Errorf("%w: part too large", ErrMalformedForm)
+)
+
+const (
+	DefaultMaxFormMemory = 1 << 20
+	DefaultMaxFormParts  = 1000
+	DefaultMaxPartBytes  = DefaultMaxBodyBytes
+)
+
+// FormLimits bounds the parsing of multipart/form-data bodies, zero fields
+// take the defaults
+type FormLimits struct {
+	// MaxMemory is the total size of the file parts kept in memory, the file
+	// parts which would exceed it are written to temporary files
+	MaxMemory int64
+	// MaxParts bounds the number of parts, fields and files alike
+	MaxParts int
+	// MaxPartBytes bounds the size of each part
+	MaxPartBytes int64
+}
+
+func (l FormLimits) withDefaults() FormLimits {
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = DefaultMaxFormMemory
+	}
+	if l.MaxParts <= 0 {
+		l.MaxParts = DefaultMaxFormParts
+	}
+	if l.MaxPartBytes <= 0 {
+		l.MaxPartBytes = DefaultMaxPartBytes
+	}
+	return l
+}
+
+// MultipartForm is a parsed multipart/form-data body
+type MultipartForm struct {
+	Value url.Values
+	File  map[string][]*FilePart
+}
+
+// RemoveAll removes the temporary files of the form
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, parts := range f.File {
+		for _, p := range parts {
+			if p.tmpfile == "" {
+				continue
+			}
+			if err := os.Remove(p.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			p.tmpfile = ""
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FilePart is a file of a multipart form, its content is either in memory or
+// in a temporary file
+type FilePart struct {
+	// Filename is the base name given by the client, it must not be trusted
+	// as a path
+	Filename string
+	Headers  *headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the content of a FilePart
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the content of the file, the caller must close it
+func (p *FilePart) Open() (File, error) {
+	if p.tmpfile != "" {
+		return os.Open(p.tmpfile)
+	}
+	return memFile{bytes.NewReader(p.content)}, nil
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error {
+	return nil
+}
+
+// ParseForm fills Form with the query parameters and the fields of an
+// application/x-www-form-urlencoded body, which also fill PostForm. Fields of
+// the body come first. It does nothing once the form is parsed
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	r.PostForm = url.Values{}
+	if mediaType, _, _ := mime.ParseMediaType(r.Headers.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(r.Body))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedForm, err)
+		}
+		r.PostForm = values
+	}
+
+	r.Form = url.Values{}
+	for k, v := range r.PostForm {
+		r.Form[k] = append(r.Form[k], v...)
+	}
+	if _, query, ok := strings.Cut(r.RequestLine.RequestTarget, "?"); ok {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedForm, err)
+		}
+		for k, v := range values {
+			r.Form[k] = append(r.Form[k], v...)
+		}
+	}
+	return nil
+}
+
+// ParseMultipartForm parses a multipart/form-data body into MultipartForm,
+// its fields are also added to Form and PostForm. The temporary files are
+// removed by RemoveTempFiles, which the server calls once the handler returns.
+// It does nothing once the form is parsed
+func (r *Request) ParseMultipartForm(l FormLimits) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return fmt.Errorf("%w: missing boundary", ErrMalformedForm)
+	}
+
+	form, err := readMultipartForm(multipart.NewReader(bytes.NewReader(r.Body), boundary), l.withDefaults())
+	if err != nil {
+		return err
+	}
+	r.MultipartForm = form
+	if r.temp == nil {
+		r.temp = &tempFiles{}
+	}
+	r.temp.add(form)
+	for k, v := range form.Value {
+		r.Form[k] = append(r.Form[k], v...)
+		r.PostForm[k] = append(r.PostForm[k], v...)
+	}
+	return nil
+}
+
+func readMultipartForm(mr *multipart.Reader, l FormLimits) (*MultipartForm, error) {
+	form := &MultipartForm{
+		Value: url.Values{},
+		File:  map[string][]*FilePart{},
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			form.RemoveAll()
+		}
+	}()
+
+	memory := l.MaxMemory
+	for parts := 0; ; parts++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			ok = true
+			return form, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+		}
+		if parts == l.MaxParts {
+			return nil, ErrTooManyParts
+		}
+
+		name := p.FormName()
+		if name == "" {
+			continue
+		}
+		lr := io.LimitReader(p, l.MaxPartBytes+1)
+
+		filename := p.FileName()
+		if filename == "" {
+			var buf bytes.Buffer
+			if _, err := buf.ReadFrom(lr); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+			}
+			if int64(buf.Len()) > l.MaxPartBytes {
+				return nil, ErrPartTooLarge
+			}
+			form.Value.Add(name, buf.String())
+			continue
+		}
+
+		fp := &FilePart{
+			Filename: filename,
+			Headers:  headers.NewHeaders(),
+		}
+		for k, vs := range p.Header {
+			for _, v := range vs {
+				fp.Headers.Set(k, v)
+			}
+		}
+		// keep the file in memory as long as it fits
+		var buf bytes.Buffer
+		n, err := buf.ReadFrom(io.LimitReader(lr, memory+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedForm, err)
+		}
+		if n <= memory {
+			memory -= n
+			fp.content = buf.Bytes()
+			fp.Size = n
+		} else if err := fp.spill(&buf, lr); err != nil {
+			return nil, err
+		}
+		// register the part first, a temporary file must be removed
+		form.File[name] = append(form.File[name], fp)
+		if fp.Size > l.MaxPartBytes {
+			return nil, ErrPartTooLarge
+		}
+	}
+}
+
+// spill writes the file read so far and the rest of it to a temporary file
+func (p *FilePart) spill(head *bytes.Buffer, rest io.Reader) error {
+	f, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	p.tmpfile = f.Name()
+
+	n, err := io.Copy(f, io.MultiReader(head, rest))
+	p.Size = n
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedForm, err)
+	}
+	return f.Close()
+}
+
+// FormValue returns the first value of the field in the query, the urlencoded
+// or the multipart body, an empty string when there is none
+func (r *Request) FormValue(key string) string {
+	r.ParseMultipartForm(FormLimits{})
+	return r.Form.Get(key)
+}
+
+// PostFormValue is FormValue ignoring the query
+func (r *Request) PostFormValue(key string) string {
+	r.ParseMultipartForm(FormLimits{})
+	return r.PostForm.Get(key)
+}
+
+// FormFile returns the first file of the field of a multipart body
+func (r *Request) FormFile(key string) (*FilePart, error) {
+	if err := r.ParseMultipartForm(FormLimits{}); err != nil {
+		return nil, err
+	}
+	if files := r.MultipartForm.File[key]; len(files) > 0 {
+		return files[0], nil
+	}
+	return nil, ErrMissingFile
+}
+
+// RemoveTempFiles removes the temporary files of the multipart forms of the
+// request and of its copies made by WithContext
+func (r *Request) RemoveTempFiles() error {
+	if r.temp == nil {
+		return nil
+	}
+	return r.temp.removeAll()
+}
+
+// tempFiles tracks the multipart forms holding temporary files
+type tempFiles struct {
+	mu    sync.Mutex
+	forms []*MultipartForm
+}
+
+func (t *tempFiles) add(form *MultipartForm) {
+	t.mu.Lock()
+	t.forms = append(t.forms, form)
+	t.mu.Unlock()
+}
+
+func (t *tempFiles) removeAll() error {
+	t.mu.Lock()
+	forms := t.forms
+	t.forms = nil
+	t.mu.Unlock()
+
+	var errs []error
+	for _, form := range forms {
+		errs = append(errs, form.RemoveAll())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,175 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFormRequest(target, contentType string, body []byte) *Request {
+	h := headers.NewHeaders()
+	h.Replace("Content-Type", contentType)
+	h.Replace("Content-Length", strconv.Itoa(len(body)))
+	return &Request{
+		RequestLine: RequestLine{HttpVersion: "1.1", RequestTarget: target, Method: "POST"},
+		Headers:     h,
+		Body:        body,
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	r := newFormRequest("/search?q=go&page=2", "application/x-www-form-urlencoded; charset=utf-8", []byte("q=gopher&name=Jane+Doe&empty="))
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"gopher", "go"}, r.Form["q"])
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Equal(t, "Jane Doe", r.FormValue("name"))
+	assert.Equal(t, "gopher", r.PostFormValue("q"))
+	assert.Empty(t, r.PostFormValue("page"))
+	assert.Contains(t, r.PostForm, "empty")
+
+	// other bodies are left alone
+	r = newFormRequest("/?a=1", "text/plain", []byte("b=2"))
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "1", r.FormValue("a"))
+	assert.Empty(t, r.FormValue("b"))
+
+	r = newFormRequest("/", "application/x-www-form-urlencoded", []byte("a=%zz"))
+	assert.ErrorIs(t, r.ParseForm(), ErrMalformedForm)
+	r = newFormRequest("/?a=%zz", "text/plain", nil)
+	assert.ErrorIs(t, r.ParseForm(), ErrMalformedForm)
+}
+
+type formPart struct {
+	name, filename, contentType, content string
+}
+
+func multipartBody(t *testing.T, parts ...formPart) (string, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		if p.filename == "" {
+			require.NoError(t, mw.WriteField(p.name, p.content))
+			continue
+		}
+		h := map[string][]string{
+			"Content-Disposition": {`form-data; name="` + p.name + `"; filename="` + p.filename + `"`},
+			"Content-Type":        {p.contentType},
+		}
+		w, err := mw.CreatePart(h)
+		require.NoError(t, err)
+		io.WriteString(w, p.content)
+	}
+	require.NoError(t, mw.Close())
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+func readFile(t *testing.T, fp *FilePart) string {
+	t.Helper()
+	f, err := fp.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	p, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(p)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	contentType, body := multipartBody(t,
+		formPart{name: "title", content: "holiday"},
+		formPart{name: "photo", filename: "../../etc/a.txt", contentType: "text/plain", content: "small"},
+		formPart{name: "photo", filename: "b.txt", contentType: "text/plain", content: big},
+	)
+	r := newFormRequest("/upload?album=1", contentType, body)
+	require.NoError(t, r.ParseMultipartForm(FormLimits{MaxMemory: 10}))
+	defer r.RemoveTempFiles()
+
+	assert.Equal(t, "holiday", r.FormValue("title"))
+	assert.Equal(t, "holiday", r.PostFormValue("title"))
+	assert.Equal(t, "1", r.FormValue("album"))
+
+	files := r.MultipartForm.File["photo"]
+	require.Len(t, files, 2)
+	assert.Equal(t, "a.txt", files[0].Filename)
+	assert.Equal(t, "text/plain", files[0].Headers.Get("Content-Type"))
+	assert.Equal(t, int64(5), files[0].Size)
+	assert.Empty(t, files[0].tmpfile)
+	assert.Equal(t, "small", readFile(t, files[0]))
+
+	// above the memory threshold the file is on disk
+	tmpfile := files[1].tmpfile
+	require.NotEmpty(t, tmpfile)
+	assert.Equal(t, int64(100), files[1].Size)
+	assert.Equal(t, big, readFile(t, files[1]))
+
+	fp, err := r.FormFile("photo")
+	require.NoError(t, err)
+	assert.Equal(t, files[0], fp)
+	_, err = r.FormFile("missing")
+	assert.ErrorIs(t, err, ErrMissingFile)
+
+	require.NoError(t, r.RemoveTempFiles())
+	_, err = os.Stat(tmpfile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseMultipartFormLimits(t *testing.T) {
+	contentType, body := multipartBody(t,
+		formPart{name: "a", content: "1"},
+		formPart{name: "b", content: "2"},
+		formPart{name: "c", content: "3"},
+	)
+	r := newFormRequest("/", contentType, body)
+	assert.ErrorIs(t, r.ParseMultipartForm(FormLimits{MaxParts: 2}), ErrTooManyParts)
+	r = newFormRequest("/", contentType, body)
+	assert.NoError(t, r.ParseMultipartForm(FormLimits{MaxParts: 3}))
+
+	contentType, body = multipartBody(t, formPart{name: "a", content: "too long"})
+	r = newFormRequest("/", contentType, body)
+	assert.ErrorIs(t, r.ParseMultipartForm(FormLimits{MaxPartBytes: 4}), ErrPartTooLarge)
+
+	// a file spilled to disk before failing is removed
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	contentType, body = multipartBody(t, formPart{name: "f", filename: "f.txt", contentType: "text/plain", content: "too long"})
+	r = newFormRequest("/", contentType, body)
+	assert.ErrorIs(t, r.ParseMultipartForm(FormLimits{MaxMemory: 2, MaxPartBytes: 4}), ErrPartTooLarge)
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	r = newFormRequest("/", "application/json", []byte("{}"))
+	assert.ErrorIs(t, r.ParseMultipartForm(FormLimits{}), ErrNotMultipart)
+	r = newFormRequest("/", "multipart/form-data", body)
+	assert.ErrorIs(t, r.ParseMultipartForm(FormLimits{}), ErrMalformedForm)
+	r = newFormRequest("/", contentType, body[:len(body)-10])
+	assert.ErrorIs(t, r.ParseMultipartForm(FormLimits{}), ErrMalformedForm)
+}
+
+func TestRemoveTempFilesOfCopies(t *testing.T) {
+	contentType, body := multipartBody(t, formPart{name: "f", filename: "f.txt", contentType: "text/plain", content: "on disk"})
+	r := newFormRequest("/", contentType, body)
+	type key struct{}
+	r2 := r.WithContext(context.WithValue(r.Context(), key{}, "v"))
+	assert.Equal(t, "v", r2.Context().Value(key{}))
+	assert.Nil(t, r.Context().Value(key{}))
+
+	require.NoError(t, r2.ParseMultipartForm(FormLimits{MaxMemory: 1}))
+	tmpfile := r2.MultipartForm.File["f"][0].tmpfile
+	require.NotEmpty(t, tmpfile)
+	assert.Nil(t, r.MultipartForm)
+
+	require.NoError(t, r.RemoveTempFiles())
+	_, err := os.Stat(tmpfile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -2,16 +2,24 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"slices"
+	"net"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
 
 	"github.com/phungducminh/httpfromtcp/internal/headers"
 )
 
-var ls = []byte("\r\n")
+var (
+	ls = []byte("\r\n")
+	sp = []byte(" ")
+)
 
 var (
 	ErrMalformedRequest                     = fmt.Errorf("request: malformed request")
@@ -44,16 +52,128 @@ type Request struct {
 	Headers     *headers.Headers
 	Body        []byte
 	state       RequestState
+
+	// RemoteAddr is the network address of the client which sent the request,
+	// it is set by the server
+	RemoteAddr string
+
+	// Form holds the query parameters and the fields of the body, PostForm
+	// only the latter. They are filled by ParseForm or ParseMultipartForm
+	Form          url.Values
+	PostForm      url.Values
+	MultipartForm *MultipartForm
+
+	// ctx carries the values middlewares attach to the request, see
+	// WithContext
+	ctx context.Context
+	// temp is shared with the copies of the request so the temporary files
+	// of their forms are removed with the request's
+	temp *tempFiles
+
+	// buffered holds bytes read from the connection after the end of the
+	// request, they belong to whatever the client sends next
+	buffered []byte
+
+	// h is the storage for Headers, reused by pooled requests
+	h *headers.Headers
+	// noCopy is set for requests from ReadRequest, whose fields reference
+	// the pooled read buffer buf
+	noCopy bool
+	buf    *[]byte
+
+	limits Limits
+	// offset counts the bytes consumed by previous parse calls
+	offset int
 }
 
 func RequestFromReader(r io.Reader) (*Request, error) {
+	return RequestFromReaderLimits(r, Limits{})
+}
+
+// RequestFromReaderLimits is RequestFromReader failing with a ParseError
+// when a part of the request exceeds its limit
+func RequestFromReaderLimits(r io.Reader, l Limits) (*Request, error) {
 	req := newRequest()
-	// TODO: overflow 1024 bytes
-	b := make([]byte, 1024)
-	end := 0
+	req.limits = l.withDefaults()
+	bp := getBuffer()
+	defer putBuffer(bp)
+
+	if err := req.read(r, bp); err != nil {
+		return nil, err
+	}
+	// everything else was copied out of the buffer while parsing
+	if len(req.buffered) > 0 {
+		req.buffered = bytes.Clone(req.buffered)
+	}
+
+	return req, nil
+}
+
+// ReadRequest is RequestFromReader without the copies: the request comes from
+// a pool and its strings, body and buffered bytes reference a pooled read
+// buffer. Common methods and header names are interned, so parsing a typical
+// request doesn't allocate once the pools are warm. Release must be called
+// when done with the request, nothing read from it may be retained after
+func ReadRequest(r io.Reader) (*Request, error) {
+	return ReadRequestLimits(r, Limits{})
+}
+
+// ReadRequestLimits is ReadRequest with limits, as RequestFromReaderLimits
+func ReadRequestLimits(r io.Reader, l Limits) (*Request, error) {
+	req := requestPool.Get().(*Request)
+	req.state = Initialized
+	req.limits = l.withDefaults()
+	req.noCopy = true
+	req.buf = getBuffer()
 
-	// buf[:end] denote the available buffer to be parsed by request
+	if err := req.read(r, req.buf); err != nil {
+		req.Release()
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Release puts a request obtained from ReadRequest back into the pool, it is
+// a no-op for other requests
+func (r *Request) Release() {
+	if !r.noCopy {
+		return
+	}
+	r.RemoveTempFiles()
+	putBuffer(r.buf)
+	h := r.h
+	h.Reset()
+	*r = Request{h: h}
+	requestPool.Put(r)
+}
+
+// read parses the request from r into the buffer *bp, which is replaced by a
+// larger one when needed
+func (req *Request) read(r io.Reader, bp *[]byte) error {
+	b := *bp
+	start, end := 0, 0
+
+	// buf[start:end] denote the available buffer to be parsed by request
 	for !req.done() {
+		if end == len(b) {
+			if start > 0 && !req.noCopy {
+				// nothing references the parsed bytes, make room by shifting
+				// the available buffer to left
+				copy(b, b[start:end])
+				end -= start
+				start = 0
+			} else {
+				// the buffer is full but parsing needs more data, grow it.
+				// Without copies the parsed fields still reference the old
+				// buffer, which is left to the garbage collector
+				nb := make([]byte, 2*len(b))
+				copy(nb, b[:end])
+				b = nb
+				*bp = nb
+			}
+		}
+
 		eof := false
 		// read a chunk
 		rn, err := r.Read(b[end:])
@@ -61,28 +181,93 @@ func RequestFromReader(r io.Reader) (*Request, error) {
 			if errors.Is(err, io.EOF) {
 				eof = true
 			} else {
-				return nil, err
+				return err
 			}
 		}
 
 		end += rn
-		pn, err := req.parse(b[:end], eof)
+		pn, err := req.parse(b[start:end], eof)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		// shift available buffer to left
-		copy(b, b[pn:end])
-		end -= pn
+		start += pn
+		req.offset += pn
 
 		if eof && !req.done() {
-			// EOF but parsing is not yet completed, there must be parsing 
+			// EOF but parsing is not yet completed, there must be parsing
 			// implementation error
-			return nil, fmt.Errorf("request: expect parsing completed after received EOF")
+			return fmt.Errorf("request: expect parsing completed after received EOF")
 		}
 	}
 
-	return req, nil
+	if end > start {
+		req.buffered = b[start:end]
+	}
+
+	return nil
+}
+
+const (
+	initialBufferSize = 1024
+	// maxPooledBufferSize keeps the buffers grown by large requests out of
+	// the pool
+	maxPooledBufferSize = 64 << 10
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, initialBufferSize)
+		return &b
+	},
+}
+
+var requestPool = sync.Pool{
+	New: func() any {
+		return &Request{h: headers.NewHeaders()}
+	},
+}
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func putBuffer(bp *[]byte) {
+	if bp == nil || cap(*bp) > maxPooledBufferSize {
+		return
+	}
+	*bp = (*bp)[:cap(*bp)]
+	bufferPool.Put(bp)
+}
+
+// Buffered returns the bytes which were read from the underlying reader but
+// not consumed by the request
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// Context returns the context of the request, context.Background() unless
+// one was set with WithContext
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the request with its context changed
+// to ctx, it is how middlewares hand values to the handlers they wrap. The
+// copy shares the body and headers of r and must not be released, r must be
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	if r.temp == nil {
+		r.temp = &tempFiles{}
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
 }
 
 func newRequest() *Request {
@@ -104,36 +289,43 @@ func (r *Request) parse(p []byte, eof bool) (int, error) {
 		case Initialized:
 			r.state = ParsingRequestLine
 		case ParsingRequestLine:
-			rl, n, err := parseRequestLine(p[rn:])
+			n, err := parseRequestLine(p[rn:], &r.RequestLine, r.noCopy)
+			if err == nil && (n == 0 && len(p[rn:]) > r.limits.MaxRequestLineBytes ||
+				n-len(ls) > r.limits.MaxRequestLineBytes) {
+				err = parseError(PhaseRequestLine, ErrRequestLineTooLong, p[rn:], 0)
+			}
 			if err != nil {
-				r.state = Error
-				return rn, err
+				return rn, r.fail(err, rn)
 			}
-			if rl == nil {
+			if n == 0 {
 				return rn, nil
 			}
 
-			r.RequestLine = *rl
 			r.state = ParsingHeaders
 			rn += n
 		case ParsingHeaders:
-			h, n, err := headers.Parse(p[rn:], eof)
+			if r.h == nil {
+				r.h = headers.NewHeaders()
+			}
+			n, err := headers.ParseInto(r.h, p[rn:], eof, r.noCopy)
 			if err != nil {
-				r.state = Error
-				return rn, err
+				// n is the offset of the malformed field line
+				return rn, r.fail(parseError(PhaseHeaders, err, p[rn:], n), rn)
+			}
+			if n == 0 && len(p[rn:]) > r.limits.MaxHeaderBytes || n > r.limits.MaxHeaderBytes {
+				return rn, r.fail(parseError(PhaseHeaders, ErrRequestHeadersTooLarge, p[rn:], 0), rn)
 			}
-			if h == nil {
+			if n == 0 {
 				return rn, nil
 			}
 
-			r.Headers = h
+			r.Headers = r.h
 			r.state = ParsingBody
 			rn += n
 		case ParsingBody:
-			body, n, err := parseRequestBody(p[rn:], eof, r.Headers)
+			body, n, err := parseRequestBody(p[rn:], eof, r.Headers, r.noCopy, r.limits.MaxBodyBytes)
 			if err != nil {
-				r.state = Error
-				return rn, err
+				return rn, r.fail(err, rn)
 			}
 			if body == nil {
 				return rn, nil
@@ -148,74 +340,269 @@ func (r *Request) parse(p []byte, eof bool) (int, error) {
 	}
 }
 
+// fail moves the request to the Error state, the offset of a ParseError
+// becomes relative to the start of the request, off is where p[0] is in the
+// current parse call
+func (r *Request) fail(err error, off int) error {
+	r.state = Error
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		pe.Offset += r.offset + off
+	}
+	return err
+}
+
 func (r *Request) done() bool {
 	return r.state == Done || r.state == Error
 }
 
-func parseRequestBody(p []byte, eof bool, h *headers.Headers) ([]byte, int, error) {
+// parseRequestBody returns the body, which references p with noCopy
+func parseRequestBody(p []byte, eof bool, h *headers.Headers, noCopy bool, maxBytes int64) ([]byte, int, error) {
 	cl := h.Get("content-length")
+	if te := h.Get("transfer-encoding"); te != "" {
+		// a message with both framings is a request smuggling attempt
+		if cl != "" {
+			return nil, 0, fieldError(ErrMalformedRequestHeaders, "content-length", cl)
+		}
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return nil, 0, fieldError(ErrUnsupportedTransferEncoding, "transfer-encoding", te)
+		}
+		return parseChunkedBody(p, eof, maxBytes)
+	}
+
 	if cl == "" {
 		return []byte{}, 0, nil
 	}
 
-	n, err := strconv.ParseInt(cl, 10, 32)
-	if err != nil || n < 0 {
-		return nil, 0, ErrMalformedRequestHeaders
+	// Content-Length = 1*DIGIT, ParseInt alone would accept a sign
+	if !isDigits(cl) {
+		return nil, 0, fieldError(ErrMalformedRequestHeaders, "content-length", cl)
 	}
-
-	if eof && len(p) != int(n) {
-		return nil, 0, ErrMalformedRequestBody
+	n, err := strconv.ParseInt(cl, 10, 64)
+	if err != nil || n > maxBytes {
+		// the digits are valid, the value can only be out of range
+		return nil, 0, fieldError(ErrRequestBodyTooLarge, "content-length", cl)
 	}
 
 	if len(p) < int(n) {
+		if eof {
+			// mismatch content-length value and body length
+			return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, len(p))
+		}
 		// not enough data for request body
 		return nil, 0, nil
 	}
 
-	if len(p) > int(n) {
-		// mismatch content-length value and body length
-		return nil, 0, ErrMalformedRequestBody
+	if noCopy {
+		return p[:n:n], int(n), nil
 	}
+	// p aliases the read buffer, whatever follows the body is kept by the
+	// reader and would overwrite it
+	return bytes.Clone(p[:n]), int(n), nil
+}
+
+// fieldError reports a header field whose value makes the body unreadable,
+// it is found at the start of the body
+func fieldError(err error, name, value string) *ParseError {
+	return parseError(PhaseBody, err, []byte(name+": "+value), 0)
+}
+
+// parseChunkedBody waits for the whole chunked body and returns it decoded,
+// trailer fields are validated and discarded
+//
+// chunked-body = *chunk last-chunk trailer-section CRLF
+// chunk        = chunk-size [ chunk-ext ] CRLF chunk-data CRLF
+func parseChunkedBody(p []byte, eof bool, maxBytes int64) ([]byte, int, error) {
+	body := []byte{}
+	n := 0
+	for {
+		i := bytes.Index(p[n:], ls)
+		if i == -1 {
+			if eof {
+				return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, n)
+			}
+			return nil, 0, nil
+		}
+
+		sizeField, _, _ := bytes.Cut(p[n:n+i], []byte(";"))
+		sizeField = bytes.TrimSpace(sizeField)
+		if len(sizeField) == 0 || len(sizeField) > 15 || !isHexDigits(sizeField) {
+			return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, n)
+		}
+		size, err := strconv.ParseInt(string(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, n)
+		}
+		if int64(len(body))+size > maxBytes {
+			return nil, 0, parseError(PhaseBody, ErrRequestBodyTooLarge, p, n)
+		}
+		n += i + len(ls)
 
-	return p, len(p), nil
+		if size == 0 {
+			trailers, tn, err := headers.Parse(p[n:], eof)
+			if err != nil {
+				return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, n)
+			}
+			if trailers == nil {
+				return nil, 0, nil
+			}
+			return body, n + tn, nil
+		}
+
+		if int64(len(p[n:])) < size+int64(len(ls)) {
+			if eof {
+				return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, len(p))
+			}
+			return nil, 0, nil
+		}
+		if !bytes.Equal(p[n+int(size):n+int(size)+len(ls)], ls) {
+			return nil, 0, parseError(PhaseBody, ErrMalformedRequestBody, p, n+int(size))
+		}
+		body = append(body, p[n:n+int(size)]...)
+		n += int(size) + len(ls)
+	}
 }
 
+// parseRequestLine parses the request line into rl, it returns 0 bytes read
+// and no error when more data is needed. With noCopy the target references p
+//
 // HTTP-version  = HTTP-name "/" DIGIT "." DIGIT
 // HTTP-name     = %s"HTTP"
 // request-line  = method SP request-target SP HTTP-version
-func parseRequestLine(p []byte) (*RequestLine, int, error) {
+func parseRequestLine(p []byte, rl *RequestLine, noCopy bool) (int, error) {
 	i := bytes.Index(p, ls)
 	if i == -1 {
 		// not enough data for parsing
-		return nil, 0, nil
+		return 0, nil
 	}
-	p = p[:i]
-	parts := bytes.Split(p, []byte(" "))
-	if len(parts) != 3 {
-		return nil, 0, ErrMalformedRequestLine
+	line := p[:i]
+	method, rest, ok := bytes.Cut(line, sp)
+	if !ok {
+		return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, 0)
 	}
+	targetOff := len(method) + len(sp)
+	target, httpVersion, ok := bytes.Cut(rest, sp)
+	if !ok {
+		return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, targetOff)
+	}
+	versionOff := targetOff + len(target) + len(sp)
+	if j := bytes.IndexByte(httpVersion, ' '); j != -1 {
+		return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, versionOff+j)
+	}
+
+	if !isMethod(method) {
+		return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, 0)
+	}
+
+	if string(method) == "CONNECT" {
+		// request-target = authority-form, only used by CONNECT
+		if !isAuthority(target) {
+			return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, targetOff)
+		}
+	} else if len(target) == 0 || target[0] != '/' {
+		return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, targetOff)
+	}
+
+	if !isHTTPVersion(httpVersion) {
+		return 0, parseError(PhaseRequestLine, ErrMalformedRequestLine, p, versionOff)
+	}
+	if string(httpVersion) != "HTTP/1.1" {
+		return 0, parseError(PhaseRequestLine, ErrUnsupportedHTTPVersion, p, versionOff)
+	}
+
+	rl.HttpVersion = "1.1"
+	rl.Method = internMethod(method, noCopy)
+	if noCopy {
+		rl.RequestTarget = unsafeString(target)
+	} else {
+		rl.RequestTarget = string(target)
+	}
+	return len(line) + len(ls), nil
+}
+
+// isHTTPVersion reports whether v is well formed, whatever the version
+func isHTTPVersion(v []byte) bool {
+	return len(v) == len("HTTP/1.1") && string(v[:5]) == "HTTP/" &&
+		isDigits(string(v[5:6])) && v[6] == '.' && isDigits(string(v[7:8]))
+}
 
-	method := parts[0]
-	if !slices.Equal(method, bytes.ToUpper(method)) {
-		return nil, 0, ErrMalformedRequestLine
+// method = token, in upper case
+func isMethod(method []byte) bool {
+	if len(method) == 0 {
+		return false
 	}
+	for _, c := range method {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1:
+		default:
+			return false
+		}
+	}
+	return true
+}
 
-	target := parts[1]
-	if len(target) == 0 || bytes.Index(target, []byte("/")) != 0 {
-		return nil, 0, ErrMalformedRequestLine
+// internMethod returns the method as a string, without allocating for the
+// standard methods
+func internMethod(method []byte, noCopy bool) string {
+	switch string(method) {
+	case "GET":
+		return "GET"
+	case "HEAD":
+		return "HEAD"
+	case "POST":
+		return "POST"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "CONNECT":
+		return "CONNECT"
+	case "OPTIONS":
+		return "OPTIONS"
+	case "TRACE":
+		return "TRACE"
+	case "PATCH":
+		return "PATCH"
 	}
+	if noCopy {
+		return unsafeString(method)
+	}
+	return string(method)
+}
 
-	httpVersion := parts[2]
-	i = bytes.Index(httpVersion, []byte("/"))
-	version := string(httpVersion[i+1:])
-	if string(httpVersion[:i]) != "HTTP" || version != "1.1" {
-		return nil, 0, ErrMalformedRequestLine
+func unsafeString(p []byte) string {
+	if len(p) == 0 {
+		return ""
 	}
+	return unsafe.String(&p[0], len(p))
+}
 
-	rl := &RequestLine{
-		HttpVersion:   string(version),
-		RequestTarget: string(target),
-		Method:        string(method),
+// authority-form = uri-host ":" port
+func isAuthority(target []byte) bool {
+	host, port, err := net.SplitHostPort(string(target))
+	if err != nil || host == "" || port == "" {
+		return false
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return err == nil && n != 0
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func isHexDigits(p []byte) bool {
+	for _, c := range p {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
 	}
-	return rl, len(p) + len(ls), nil
+	return true
 }
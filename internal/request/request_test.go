@@ -1,7 +1,10 @@
 package request
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/phungducminh/httpfromtcp/internal/headers"
@@ -54,27 +57,49 @@ func TestRequestLineParse(t *testing.T) {
 	// Test: Invalid method
 	r, err = RequestFromReader(newChunkReader([]byte("get / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"), 5))
 	require.Error(t, err)
-	assert.Equal(t, err, ErrMalformedRequestLine)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
 
 	// Test: Invalid version
 	r, err = RequestFromReader(newChunkReader([]byte("get / HTTP/1.2\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"), 20))
 	require.Error(t, err)
-	assert.Equal(t, err, ErrMalformedRequestLine)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
 
 	// Test: Out of order
 	r, err = RequestFromReader(newChunkReader([]byte("GET HTTP/1.1 /\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"), 5))
 	require.Error(t, err)
-	assert.Equal(t, err, ErrMalformedRequestLine)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
 
 	// Test: Invalid http version
 	r, err = RequestFromReader(newChunkReader([]byte("GET / TCP/1.1 \r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"), 10))
 	require.Error(t, err)
-	assert.Equal(t, err, ErrMalformedRequestLine)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
 
 	// Test: Lacking method
 	_, err = RequestFromReader(newChunkReader([]byte("/coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"), 10))
 	require.Error(t, err)
-	assert.Equal(t, err, ErrMalformedRequestLine)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: CONNECT with authority-form
+	r, err = RequestFromReader(newChunkReader([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"), 4))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// Test: CONNECT without port
+	_, err = RequestFromReader(newChunkReader([]byte("CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n"), 4))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: CONNECT with origin-form
+	_, err = RequestFromReader(newChunkReader([]byte("CONNECT / HTTP/1.1\r\nHost: example.com\r\n\r\n"), 4))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
+
+	// Test: authority-form with other methods
+	_, err = RequestFromReader(newChunkReader([]byte("GET example.com:443 HTTP/1.1\r\nHost: example.com\r\n\r\n"), 4))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
 }
 
 func TestRequestFromReaderParseHeaders(t *testing.T) {
@@ -126,7 +151,7 @@ func TestRequestFromReaderParseHeaders(t *testing.T) {
 	for _, tt := range tests {
 		r := newChunkReader([]byte(tt.data), tt.numBytesPerRead)
 		req, err := RequestFromReader(r)
-		if tt.expectErr != nil && tt.expectErr != err {
+		if tt.expectErr != nil && !errors.Is(err, tt.expectErr) {
 			t.Errorf("data='%s', numBytesPerRead=%d, expect error=%v, actual=%v", tt.data, tt.numBytesPerRead, tt.expectErr, err)
 		}
 		if tt.expectErr == nil {
@@ -200,6 +225,77 @@ func TestRequestFromReaderParseBody(t *testing.T) {
 			expecteError:    nil,
 			expectBody:      "",
 		},
+		{
+			description: "chunked body with extensions and trailers",
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"6;name=value\r\nhello \r\n" +
+				"7\r\nworld!\n\r\n" +
+				"0\r\n" +
+				"X-Checksum: abc\r\n" +
+				"\r\n",
+			numBytesPerRead: 3,
+			expecteError:    nil,
+			expectBody:      "hello world!\n",
+		},
+		{
+			description: "chunked body without last chunk",
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n",
+			numBytesPerRead: 3,
+			expecteError:    ErrMalformedRequestBody,
+			expectBody:      "",
+		},
+		{
+			description: "chunk data longer than chunk size",
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"2\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+			expecteError:    ErrMalformedRequestBody,
+			expectBody:      "",
+		},
+		{
+			description: "both content-length and transfer-encoding",
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: 5\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+			expecteError:    ErrMalformedRequestHeaders,
+			expectBody:      "",
+		},
+		{
+			description: "body larger than the read buffer",
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: 3000\r\n" +
+				"\r\n" +
+				strings.Repeat("abc", 1000),
+			numBytesPerRead: 512,
+			expecteError:    nil,
+			expectBody:      strings.Repeat("abc", 1000),
+		},
+		{
+			description: "signed content-length",
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: +5\r\n" +
+				"\r\n" +
+				"hello",
+			numBytesPerRead: 3,
+			expecteError:    ErrMalformedRequestHeaders,
+			expectBody:      "",
+		},
 		{
 			description: "content-length greater and empty body, mismatch",
 			data: "GET /submit HTTP/1.1\r\n" +
@@ -217,7 +313,7 @@ func TestRequestFromReaderParseBody(t *testing.T) {
 		r, err := RequestFromReader(reader)
 		if tt.expecteError != nil {
 			require.Error(t, err, tt.description)
-			assert.Equal(t, tt.expecteError, err, tt.description)
+			assert.ErrorIs(t, err, tt.expecteError, tt.description)
 		} else {
 			require.NotNil(t, r, tt.description)
 			require.NotNil(t, r.Body, tt.description)
@@ -225,3 +321,104 @@ func TestRequestFromReaderParseBody(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestFromReaderPipelined(t *testing.T) {
+	data := "POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET / HTTP/1.1\r\n\r\n"
+	for _, numBytesPerRead := range []int{1, 3, 1024} {
+		r, err := RequestFromReader(newChunkReader([]byte(data), numBytesPerRead))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+		if numBytesPerRead == 1024 {
+			assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(r.Buffered()))
+		}
+	}
+}
+
+func TestRequestLineParseMissingVersionSlash(t *testing.T) {
+	_, err := RequestFromReader(newChunkReader([]byte("GET / HTTP1.1\r\n\r\n"), 3))
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
+}
+
+func TestReadRequest(t *testing.T) {
+	data := "POST /submit?q=1 HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Custom-Header: Value\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"helloGET / HTTP/1.1\r\n\r\n"
+	for _, numBytesPerRead := range []int{1, 3, 2048} {
+		r, err := ReadRequest(newChunkReader([]byte(data), numBytesPerRead))
+		require.NoError(t, err)
+		assert.Equal(t, "POST", r.RequestLine.Method)
+		assert.Equal(t, "/submit?q=1", r.RequestLine.RequestTarget)
+		assert.Equal(t, "localhost:42069", r.Headers.Get("Host"))
+		assert.Equal(t, "Value", r.Headers.Get("x-custom-header"))
+		assert.Equal(t, "hello", string(r.Body))
+		r.Release()
+	}
+
+	// a request larger than the pooled buffer
+	large := "POST / HTTP/1.1\r\nContent-Length: 3000\r\n\r\n" + strings.Repeat("abc", 1000)
+	r, err := ReadRequest(newChunkReader([]byte(large), 512))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("abc", 1000), string(r.Body))
+	r.Release()
+
+	_, err = ReadRequest(newChunkReader([]byte("GET / HTTP1.1\r\n\r\n"), 3))
+	assert.ErrorIs(t, err, ErrMalformedRequestLine)
+}
+
+func TestReadRequestAllocs(t *testing.T) {
+	if testing.CoverMode() != "" || raceEnabled {
+		t.Skip("instrumentation allocates")
+	}
+	data := []byte("GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
+	reader := bytes.NewReader(data)
+	allocs := testing.AllocsPerRun(100, func() {
+		reader.Reset(data)
+		r, err := ReadRequest(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Release()
+	})
+	assert.Zero(t, allocs)
+}
+
+var benchmarkRequest = []byte("POST /api/v1/orders?expand=items HTTP/1.1\r\n" +
+	"Host: api.example.com\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64)\r\n" +
+	"Accept: application/json\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Authorization: Bearer abc.def.ghi\r\n" +
+	"Content-Type: application/json\r\n" +
+	"X-Request-Id: 7f3c2a\r\n" +
+	"Content-Length: 17\r\n" +
+	"\r\n" +
+	`{"item":"coffee"}`)
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	reader := bytes.NewReader(benchmarkRequest)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkRequest)))
+	for b.Loop() {
+		reader.Reset(benchmarkRequest)
+		if _, err := RequestFromReader(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	reader := bytes.NewReader(benchmarkRequest)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkRequest)))
+	for b.Loop() {
+		reader.Reset(benchmarkRequest)
+		r, err := ReadRequest(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+		r.Release()
+	}
+}
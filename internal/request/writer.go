@@ -0,0 +1,82 @@
+package request
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteTo writes the request in its wire format. The body is framed with
+// chunked encoding when the Transfer-Encoding header asks for it, otherwise
+// Content-Length is set to the length of the body whenever there is a body or
+// the header was present. Header fields are written in lexical order so the
+// output is deterministic
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	version := r.RequestLine.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+
+	// multi-line fields as Cookie have several values
+	fields := map[string][]string{}
+	if r.Headers != nil {
+		r.Headers.ForEach(func(key, value string) {
+			fields[key] = append(fields[key], value)
+		})
+	}
+
+	chunked := false
+	if te, ok := fields["transfer-encoding"]; ok {
+		codings := strings.Split(te[len(te)-1], ",")
+		chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+		delete(fields, "content-length")
+	}
+	if !chunked {
+		if _, ok := fields["content-length"]; ok || len(r.Body) > 0 {
+			fields["content-length"] = []string{strconv.Itoa(len(r.Body))}
+		}
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	fmt.Fprintf(bw, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, version)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		for _, v := range fields[k] {
+			fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+		}
+	}
+	bw.Write(ls)
+
+	if chunked {
+		if len(r.Body) > 0 {
+			fmt.Fprintf(bw, "%x\r\n", len(r.Body))
+			bw.Write(r.Body)
+			bw.Write(ls)
+		}
+		bw.WriteString("0\r\n\r\n")
+	} else {
+		bw.Write(r.Body)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,110 @@
+package request
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(method, target string, body string, kv ...string) *Request {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return &Request{
+		RequestLine: RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+		Body:        []byte(body),
+	}
+}
+
+func headersMap(h *headers.Headers) map[string]string {
+	m := map[string]string{}
+	h.ForEach(func(key, value string) {
+		m[key] = value
+	})
+	return m
+}
+
+func TestRequestWriteToRoundTrip(t *testing.T) {
+	corpus := []*Request{
+		newTestRequest("GET", "/", "", "Host", "localhost:42069"),
+		newTestRequest("GET", "/coffee?size=large&sugar=0", "", "Host", "localhost:42069", "User-Agent", "curl/7.81.0", "Accept", "*/*"),
+		newTestRequest("POST", "/submit", "hello world!\n", "Host", "localhost:42069", "Content-Length", "13"),
+		newTestRequest("POST", "/empty", "", "Host", "localhost:42069", "Content-Length", "0"),
+		newTestRequest("PUT", "/multi", "{}", "Host", "localhost", "Content-Length", "2", "Bar", "abc", "Bar", "xyz"),
+		newTestRequest("POST", "/chunked", "streamed body", "Host", "localhost", "Transfer-Encoding", "chunked"),
+		newTestRequest("POST", "/chunked-empty", "", "Host", "localhost", "Transfer-Encoding", "chunked"),
+		newTestRequest("POST", "/large", strings.Repeat("0123456789", 500), "Host", "localhost", "Content-Length", "5000"),
+		newTestRequest("POST", "/large-chunked", strings.Repeat("abcdefghij", 500), "Host", "localhost", "Transfer-Encoding", "chunked"),
+		newTestRequest("CONNECT", "example.com:443", "", "Host", "example.com:443"),
+		newTestRequest("DELETE", "/items/1", "", "Host", "localhost", "Authorization", "Bearer abc.def.ghi", "X-Empty", ""),
+	}
+
+	for _, r := range corpus {
+		var buf bytes.Buffer
+		n, err := r.WriteTo(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+
+		for _, numBytesPerRead := range []int{1, 7, 1024} {
+			parsed, err := RequestFromReader(newChunkReader(buf.Bytes(), numBytesPerRead))
+			require.NoError(t, err, buf.String())
+			assert.Equal(t, r.RequestLine, parsed.RequestLine)
+			assert.Equal(t, headersMap(r.Headers), headersMap(parsed.Headers))
+			assert.Equal(t, string(r.Body), string(parsed.Body))
+		}
+	}
+}
+
+func TestRequestWriteTo(t *testing.T) {
+	tests := []struct {
+		description string
+		req         *Request
+		expect      string
+	}{
+		{
+			description: "content-length is added for a body",
+			req:         newTestRequest("POST", "/submit", "hello", "Host", "localhost"),
+			expect:      "POST /submit HTTP/1.1\r\ncontent-length: 5\r\nhost: localhost\r\n\r\nhello",
+		},
+		{
+			description: "content-length is corrected",
+			req:         newTestRequest("POST", "/submit", "hello", "Host", "localhost", "Content-Length", "42"),
+			expect:      "POST /submit HTTP/1.1\r\ncontent-length: 5\r\nhost: localhost\r\n\r\nhello",
+		},
+		{
+			description: "no content-length without body",
+			req:         newTestRequest("GET", "/", "", "Host", "localhost"),
+			expect:      "GET / HTTP/1.1\r\nhost: localhost\r\n\r\n",
+		},
+		{
+			description: "chunked body drops content-length",
+			req:         newTestRequest("POST", "/", "hello", "Transfer-Encoding", "chunked", "Content-Length", "5"),
+			expect:      "POST / HTTP/1.1\r\ntransfer-encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		},
+		{
+			description: "every cookie line is kept",
+			req:         newTestRequest("GET", "/", "", "Cookie", "a=1", "Cookie", "b=2"),
+			expect:      "GET / HTTP/1.1\r\ncookie: a=1\r\ncookie: b=2\r\n\r\n",
+		},
+		{
+			description: "missing version defaults to 1.1",
+			req: &Request{
+				RequestLine: RequestLine{Method: "GET", RequestTarget: "/"},
+			},
+			expect: "GET / HTTP/1.1\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		_, err := tt.req.WriteTo(&buf)
+		require.NoError(t, err, tt.description)
+		assert.Equal(t, tt.expect, buf.String(), tt.description)
+	}
+}
@@ -0,0 +1,115 @@
+package response
+
+import (
+	"io"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+)
+
+type holdState int
+
+const (
+	holdNone holdState = iota
+	// holdHead waits for the status line and the headers
+	holdHead
+	// holdBody collects the body
+	holdBody
+)
+
+// Hold keeps the next response in memory instead of writing it, so a
+// middleware can look at the whole response before its head is written. The
+// status line, headers and body are held until Unhold or DiscardHeld. The
+// header hooks run when the response is eventually written.
+//
+// want is given the status and headers of the response and reports whether
+// to hold it, nil holds every response. Responses it declines, chunked
+// responses, those announcing a Content-Length over maxBody and bodies which
+// turn out larger than maxBody bytes are written as soon as that is known, as
+// is anything written before the headers
+func (w *Writer) Hold(maxBody int, want func(statusCode StatusCode, h *headers.Headers) bool) {
+	if w.wroteHeaders || w.hijacked {
+		return
+	}
+	w.hold = holdHead
+	w.holdMax = maxBody
+	w.holdWant = want
+}
+
+// holds reports whether the response with the headers h is held
+func (w *Writer) holds(h *headers.Headers) bool {
+	if isChunked(h) {
+		return false
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := parseContentLength(cl); err == nil && n > int64(w.holdMax) {
+			return false
+		}
+	}
+	return w.holdWant == nil || w.holdWant(w.heldStatus, h)
+}
+
+// Held returns the held response, ok is false unless its status line and
+// headers are held. The headers can be changed before Unhold writes them
+func (w *Writer) Held() (statusCode StatusCode, h *headers.Headers, body []byte, ok bool) {
+	if w.hold != holdBody {
+		return 0, nil, nil, false
+	}
+	return w.heldStatus, w.heldHeaders, w.heldBody, true
+}
+
+// Unhold stops holding and writes what is held, it returns the first error met
+// while writing
+func (w *Writer) Unhold() error {
+	if w.hold == holdNone {
+		return w.err
+	}
+	status, h, body := w.heldStatus, w.heldHeaders, w.heldBody
+	w.DiscardHeld()
+	if status != 0 {
+		w.WriteStatusLine(status)
+	}
+	if h != nil {
+		w.WriteHeaders(h)
+	}
+	if len(body) > 0 {
+		w.Write(body)
+	}
+	return w.err
+}
+
+// DiscardHeld stops holding and drops what is held, the caller writes another
+// response instead
+func (w *Writer) DiscardHeld() {
+	w.hold = holdNone
+	w.holdWant = nil
+	w.heldStatus = 0
+	w.heldHeaders = nil
+	w.heldBody = nil
+}
+
+func (w *Writer) holdWrite(p []byte) (int, error) {
+	if w.hold == holdHead {
+		// the handler writes the head itself
+		if err := w.Unhold(); err != nil {
+			return 0, err
+		}
+		return w.Write(p)
+	}
+	w.heldBody = append(w.heldBody, p...)
+	if len(w.heldBody) > w.holdMax {
+		if err := w.Unhold(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// holdFrom reads the body from r while it is held, it stops reading once the
+// body overflows and the hold gives up, the rest is left in r
+func (w *Writer) holdFrom(r io.Reader) (int64, error) {
+	limit := int64(w.holdMax - len(w.heldBody) + 1)
+	if w.hold == holdHead {
+		limit = 1
+	}
+	return io.Copy(writerOnly{w}, io.LimitReader(r, limit))
+}
@@ -0,0 +1,332 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+)
+
+// maxHeaderBytes bounds the size of the status line and header section
+const maxHeaderBytes = 64 * 1024
+
+var (
+	ErrMalformedStatusLine        = fmt.Errorf("response: malformed status line")
+	ErrMalformedResponseHeaders   = fmt.Errorf("response: malformed response headers")
+	ErrMalformedResponseBody      = fmt.Errorf("response: malformed response body")
+	ErrResponseHeadersTooLarge    = fmt.Errorf("response: response headers too large")
+	ErrMalformedContentLength     = fmt.Errorf("response: malformed response headers content-length")
+	ErrUnsupportedResponseVersion = fmt.Errorf("response: unsupported http version")
+)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    *headers.Headers
+	// Body streams the content of the response, it is empty for responses
+	// without content and it is never nil
+	Body io.ReadCloser
+	// ContentLength is -1 when the body is not framed by Content-Length
+	ContentLength int64
+	// Trailers is set for chunked bodies and filled once Body returns io.EOF
+	Trailers *headers.Headers
+	// Close reports whether the connection must be closed after the response,
+	// either because the server asked for it or because the body is delimited
+	// by the connection close
+	Close bool
+}
+
+// ResponseFromReader parses a response to a request with the given method,
+// which decides whether the response carries content. When r is not a
+// *bufio.Reader it is wrapped in one, bytes following the response are then
+// lost in its buffer
+func ResponseFromReader(r io.Reader, method string) (*Response, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	sl, err := readStatusLine(br)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readHeaders(br, maxHeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Response{
+		StatusLine:    *sl,
+		Headers:       h,
+		Body:          io.NopCloser(bytes.NewReader(nil)),
+		ContentLength: -1,
+	}
+	res.Close = closeRequested(sl.HttpVersion, h)
+
+	if !BodyAllowed(method, sl.StatusCode) {
+		return res, nil
+	}
+
+	if te := h.Get("Transfer-Encoding"); te != "" {
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			// the final coding is not chunked, the body is delimited by the
+			// connection close
+			res.Body = io.NopCloser(br)
+			res.Close = true
+			return res, nil
+		}
+		res.Trailers = headers.NewHeaders()
+		res.Body = io.NopCloser(&chunkedReader{br: br, trailers: res.Trailers})
+		return res, nil
+	}
+
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return nil, err
+		}
+		res.ContentLength = n
+		res.Body = io.NopCloser(&lengthReader{r: br, remaining: n})
+		return res, nil
+	}
+
+	res.Body = io.NopCloser(br)
+	res.Close = true
+	return res, nil
+}
+
+// BodyAllowed reports whether a response to the method with the status code
+// carries content, see RFC 9112 section 6.3
+func BodyAllowed(method string, statusCode StatusCode) bool {
+	if method == "HEAD" {
+		return false
+	}
+	if statusCode < 200 || statusCode == NoContent || statusCode == NotModified {
+		return false
+	}
+	return true
+}
+
+func closeRequested(version string, h *headers.Headers) bool {
+	keepAlive := version == "1.1"
+	for _, opt := range strings.Split(h.Get("Connection"), ",") {
+		opt = strings.TrimSpace(opt)
+		if strings.EqualFold(opt, "close") {
+			return true
+		}
+		if strings.EqualFold(opt, "keep-alive") {
+			keepAlive = true
+		}
+	}
+	return !keepAlive
+}
+
+// parseContentLength accepts a single value or a list of identical values,
+// which some senders produce when merging duplicated fields
+func parseContentLength(v string) (int64, error) {
+	var n int64 = -1
+	for _, part := range strings.Split(v, ",") {
+		m, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || m < 0 || (n != -1 && m != n) {
+			return 0, ErrMalformedContentLength
+		}
+		n = m
+	}
+	return n, nil
+}
+
+// HTTP-version = HTTP-name "/" DIGIT "." DIGIT
+// status-line  = HTTP-version SP status-code SP [ reason-phrase ]
+func readStatusLine(br *bufio.Reader) (*StatusLine, error) {
+	line, err := readLine(br, ErrMalformedStatusLine)
+	if err != nil {
+		return nil, err
+	}
+
+	httpVersion, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, ErrMalformedStatusLine
+	}
+	name, version, ok := strings.Cut(httpVersion, "/")
+	if !ok || name != "HTTP" {
+		return nil, ErrMalformedStatusLine
+	}
+	if version != "1.1" && version != "1.0" {
+		return nil, ErrUnsupportedResponseVersion
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 {
+		return nil, ErrMalformedStatusLine
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 {
+		return nil, ErrMalformedStatusLine
+	}
+
+	return &StatusLine{
+		HttpVersion:  version,
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+// readHeaders reads field lines up to and including the empty line, then
+// parses them with headers.Parse
+func readHeaders(br *bufio.Reader, limit int) (*headers.Headers, error) {
+	var block bytes.Buffer
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, ErrResponseHeadersTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedResponseHeaders, err)
+		}
+		block.Write(line)
+		if block.Len() > limit {
+			return nil, ErrResponseHeadersTooLarge
+		}
+		if bytes.Equal(line, []byte("\r\n")) {
+			break
+		}
+	}
+
+	h, _, err := headers.Parse(block.Bytes(), true)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedResponseHeaders, err)
+	}
+	return h, nil
+}
+
+// readLine reads a line terminated by CRLF, malformed is returned when the line
+// ends with a bare LF
+func readLine(br *bufio.Reader, malformed error) (string, error) {
+	line, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrResponseHeadersTooLarge
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return "", malformed
+	}
+	return s, nil
+}
+
+// lengthReader reads a body framed by Content-Length, an early EOF is an error
+// instead of the end of the body
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if err == io.EOF && l.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// chunkedReader decodes a chunked body and collects its trailers
+//
+// chunked-body = *chunk last-chunk trailer-section CRLF
+// chunk        = chunk-size [ chunk-ext ] CRLF chunk-data CRLF
+type chunkedReader struct {
+	br        *bufio.Reader
+	remaining int64
+	done      bool
+	trailers  *headers.Headers
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		size, err := c.readChunkSize()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			if err := c.readTrailers(); err != nil {
+				return 0, err
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && c.remaining == 0 {
+		// each chunk data is followed by CRLF
+		line, lerr := readLine(c.br, ErrMalformedResponseBody)
+		if lerr != nil || line != "" {
+			return n, ErrMalformedResponseBody
+		}
+	}
+	return n, err
+}
+
+func (c *chunkedReader) readChunkSize() (int64, error) {
+	line, err := readLine(c.br, ErrMalformedResponseBody)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	// chunk extensions are ignored
+	size, _, _ := strings.Cut(line, ";")
+	size = strings.TrimSpace(size)
+	if size == "" || len(size) > 16 {
+		return 0, ErrMalformedResponseBody
+	}
+	n, err := strconv.ParseUint(size, 16, 63)
+	if err != nil {
+		return 0, ErrMalformedResponseBody
+	}
+	return int64(n), nil
+}
+
+func (c *chunkedReader) readTrailers() error {
+	h, err := readHeaders(c.br, maxHeaderBytes)
+	if err != nil {
+		return err
+	}
+	h.ForEach(func(key, value string) {
+		c.trailers.Set(key, value)
+	})
+	return nil
+}
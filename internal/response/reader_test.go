@@ -0,0 +1,292 @@
+package response
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            []byte
+	numBytesPerRead int
+	end             int
+}
+
+func newChunkReader(data []byte, numBytesPerRead int) *chunkReader {
+	return &chunkReader{
+		data:            data,
+		numBytesPerRead: numBytesPerRead,
+		end:             0, // last read position
+	}
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.end >= len(cr.data) {
+		return 0, io.EOF
+	}
+	nexti := min(cr.end+cr.numBytesPerRead, len(cr.data))
+	n = copy(p, cr.data[cr.end:nexti])
+	cr.end += n
+	return n, nil
+}
+
+func TestResponseFromReader(t *testing.T) {
+	tests := []struct {
+		description         string
+		data                string
+		method              string
+		expectStatusCode    StatusCode
+		expectReason        string
+		expectHeaders       map[string]string
+		expectBody          string
+		expectContentLength int64
+		expectTrailers      map[string]string
+		expectClose         bool
+	}{
+		{
+			description: "content-length body",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Content-Length: 13\r\n" +
+				"\r\n" +
+				"hello world!\n",
+			method:              "GET",
+			expectStatusCode:    OK,
+			expectReason:        "OK",
+			expectHeaders:       map[string]string{"content-type": "text/plain"},
+			expectBody:          "hello world!\n",
+			expectContentLength: 13,
+		},
+		{
+			description: "chunked body with extensions and trailers",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"Trailer: X-Checksum\r\n" +
+				"\r\n" +
+				"6;ext=1\r\nhello \r\n" +
+				"b\r\nworld, 1234\r\n" +
+				"0\r\n" +
+				"X-Checksum: abc\r\n" +
+				"\r\n",
+			method:              "GET",
+			expectStatusCode:    OK,
+			expectReason:        "OK",
+			expectBody:          "hello world, 1234",
+			expectContentLength: -1,
+			expectTrailers:      map[string]string{"x-checksum": "abc"},
+		},
+		{
+			description: "body delimited by connection close",
+			data: "HTTP/1.0 200 OK\r\n" +
+				"\r\n" +
+				"until the end",
+			method:              "GET",
+			expectStatusCode:    OK,
+			expectReason:        "OK",
+			expectBody:          "until the end",
+			expectContentLength: -1,
+			expectClose:         true,
+		},
+		{
+			description: "identical content-length values",
+			data: "HTTP/1.1 201 Created\r\n" +
+				"Content-Length: 2\r\n" +
+				"Content-Length: 2\r\n" +
+				"Connection: close\r\n" +
+				"\r\n" +
+				"ok",
+			method:              "POST",
+			expectStatusCode:    Created,
+			expectReason:        "Created",
+			expectBody:          "ok",
+			expectContentLength: 2,
+			expectClose:         true,
+		},
+		{
+			description: "HEAD response has no body",
+			data: "HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 42\r\n" +
+				"\r\n",
+			method:              "HEAD",
+			expectStatusCode:    OK,
+			expectReason:        "OK",
+			expectHeaders:       map[string]string{"content-length": "42"},
+			expectContentLength: -1,
+		},
+		{
+			description:         "no content",
+			data:                "HTTP/1.1 204 No Content\r\n\r\n",
+			method:              "DELETE",
+			expectStatusCode:    NoContent,
+			expectReason:        "No Content",
+			expectContentLength: -1,
+		},
+		{
+			description: "not modified",
+			data: "HTTP/1.1 304 Not Modified\r\n" +
+				"ETag: \"abc\"\r\n" +
+				"\r\n",
+			method:              "GET",
+			expectStatusCode:    NotModified,
+			expectReason:        "Not Modified",
+			expectHeaders:       map[string]string{"etag": "\"abc\""},
+			expectContentLength: -1,
+		},
+		{
+			description:         "interim response",
+			data:                "HTTP/1.1 100 Continue\r\n\r\n",
+			method:              "POST",
+			expectStatusCode:    Continue,
+			expectReason:        "Continue",
+			expectContentLength: -1,
+		},
+		{
+			description:         "empty reason phrase",
+			data:                "HTTP/1.1 299 \r\nContent-Length: 0\r\n\r\n",
+			method:              "GET",
+			expectStatusCode:    299,
+			expectContentLength: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, numBytesPerRead := range []int{1, 3, 1024} {
+			res, err := ResponseFromReader(newChunkReader([]byte(tt.data), numBytesPerRead), tt.method)
+			require.NoError(t, err, tt.description)
+			assert.Equal(t, tt.expectStatusCode, res.StatusLine.StatusCode, tt.description)
+			assert.Equal(t, tt.expectReason, res.StatusLine.ReasonPhrase, tt.description)
+			for k, v := range tt.expectHeaders {
+				assert.Equal(t, v, res.Headers.Get(k), tt.description)
+			}
+			body, err := io.ReadAll(res.Body)
+			require.NoError(t, err, tt.description)
+			assert.Equal(t, tt.expectBody, string(body), tt.description)
+			assert.Equal(t, tt.expectContentLength, res.ContentLength, tt.description)
+			assert.Equal(t, tt.expectClose, res.Close, tt.description)
+			if tt.expectTrailers != nil {
+				require.NotNil(t, res.Trailers, tt.description)
+				for k, v := range tt.expectTrailers {
+					assert.Equal(t, v, res.Trailers.Get(k), tt.description)
+				}
+			}
+		}
+	}
+}
+
+func TestResponseFromReaderErrors(t *testing.T) {
+	tests := []struct {
+		description string
+		data        string
+		expectErr   error
+	}{
+		{
+			description: "missing status code",
+			data:        "HTTP/1.1\r\n\r\n",
+			expectErr:   ErrMalformedStatusLine,
+		},
+		{
+			description: "invalid status code",
+			data:        "HTTP/1.1 2OO OK\r\n\r\n",
+			expectErr:   ErrMalformedStatusLine,
+		},
+		{
+			description: "not http",
+			data:        "ICY 200 OK\r\n\r\n",
+			expectErr:   ErrMalformedStatusLine,
+		},
+		{
+			description: "unsupported version",
+			data:        "HTTP/2.0 200 OK\r\n\r\n",
+			expectErr:   ErrUnsupportedResponseVersion,
+		},
+		{
+			description: "bare LF",
+			data:        "HTTP/1.1 200 OK\n\n",
+			expectErr:   ErrMalformedStatusLine,
+		},
+		{
+			description: "malformed headers",
+			data:        "HTTP/1.1 200 OK\r\nContent Length: 1\r\n\r\n",
+			expectErr:   ErrMalformedResponseHeaders,
+		},
+		{
+			description: "conflicting content-length",
+			data:        "HTTP/1.1 200 OK\r\nContent-Length: 1, 2\r\n\r\nab",
+			expectErr:   ErrMalformedContentLength,
+		},
+		{
+			description: "headers never end",
+			data:        "HTTP/1.1 200 OK\r\nHost: example.com\r\n",
+			expectErr:   ErrMalformedResponseHeaders,
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := ResponseFromReader(strings.NewReader(tt.data), "GET")
+		assert.ErrorIs(t, err, tt.expectErr, tt.description)
+	}
+}
+
+func TestResponseFromReaderMalformedBody(t *testing.T) {
+	tests := []struct {
+		description string
+		data        string
+		expectErr   error
+	}{
+		{
+			description: "truncated content-length body",
+			data:        "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+			expectErr:   io.ErrUnexpectedEOF,
+		},
+		{
+			description: "invalid chunk size",
+			data:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+			expectErr:   ErrMalformedResponseBody,
+		},
+		{
+			description: "chunk longer than its size",
+			data:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhello\r\n0\r\n\r\n",
+			expectErr:   ErrMalformedResponseBody,
+		},
+		{
+			description: "missing last chunk",
+			data:        "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n",
+			expectErr:   io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		res, err := ResponseFromReader(strings.NewReader(tt.data), "GET")
+		require.NoError(t, err, tt.description)
+		_, err = io.ReadAll(res.Body)
+		assert.ErrorIs(t, err, tt.expectErr, tt.description)
+	}
+}
+
+func TestResponseFromReaderPipelined(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n"))
+
+	res, err := ResponseFromReader(br, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, Continue, res.StatusLine.StatusCode)
+
+	for _, expect := range []string{"first", "second"} {
+		res, err = ResponseFromReader(br, "GET")
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, expect, string(body))
+	}
+
+	_, err = ResponseFromReader(br, "GET")
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -1,23 +1,170 @@
 package response
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
+	"strings"
 
+	"github.com/phungducminh/httpfromtcp/internal/cookie"
 	"github.com/phungducminh/httpfromtcp/internal/headers"
 )
 
 type StatusCode int
 
 const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
+	Continue           StatusCode = 100
+	SwitchingProtocols StatusCode = 101
+
+	OK             StatusCode = 200
+	Created        StatusCode = 201
+	Accepted       StatusCode = 202
+	NoContent      StatusCode = 204
+	ResetContent   StatusCode = 205
+	PartialContent StatusCode = 206
+
+	MovedPermanently  StatusCode = 301
+	Found             StatusCode = 302
+	SeeOther          StatusCode = 303
+	NotModified       StatusCode = 304
+	TemporaryRedirect StatusCode = 307
+	PermanentRedirect StatusCode = 308
+
+	BadRequest                  StatusCode = 400
+	Unauthorized                StatusCode = 401
+	Forbidden                   StatusCode = 403
+	NotFound                    StatusCode = 404
+	MethodNotAllowed            StatusCode = 405
+	NotAcceptable               StatusCode = 406
+	RequestTimeout              StatusCode = 408
+	Conflict                    StatusCode = 409
+	Gone                        StatusCode = 410
+	LengthRequired              StatusCode = 411
+	PreconditionFailed          StatusCode = 412
+	ContentTooLarge             StatusCode = 413
+	URITooLong                  StatusCode = 414
+	UnsupportedMediaType        StatusCode = 415
+	RangeNotSatisfiable         StatusCode = 416
+	ExpectationFailed           StatusCode = 417
+	UnprocessableContent        StatusCode = 422
+	TooManyRequests             StatusCode = 429
+	RequestHeaderFieldsTooLarge StatusCode = 431
+
+	InternalServerError     StatusCode = 500
+	NotImplemented          StatusCode = 501
+	BadGateway              StatusCode = 502
+	ServiceUnavailable      StatusCode = 503
+	GatewayTimeout          StatusCode = 504
+	HTTPVersionNotSupported StatusCode = 505
 )
 
+var statusText = map[StatusCode]string{
+	Continue:           "Continue",
+	SwitchingProtocols: "Switching Protocols",
+
+	OK:             "OK",
+	Created:        "Created",
+	Accepted:       "Accepted",
+	NoContent:      "No Content",
+	ResetContent:   "Reset Content",
+	PartialContent: "Partial Content",
+
+	MovedPermanently:  "Moved Permanently",
+	Found:             "Found",
+	SeeOther:          "See Other",
+	NotModified:       "Not Modified",
+	TemporaryRedirect: "Temporary Redirect",
+	PermanentRedirect: "Permanent Redirect",
+
+	BadRequest:                  "Bad Request",
+	Unauthorized:                "Unauthorized",
+	Forbidden:                   "Forbidden",
+	NotFound:                    "Not Found",
+	MethodNotAllowed:            "Method Not Allowed",
+	NotAcceptable:               "Not Acceptable",
+	RequestTimeout:              "Request Timeout",
+	Conflict:                    "Conflict",
+	Gone:                        "Gone",
+	LengthRequired:              "Length Required",
+	PreconditionFailed:          "Precondition Failed",
+	ContentTooLarge:             "Content Too Large",
+	URITooLong:                  "URI Too Long",
+	UnsupportedMediaType:        "Unsupported Media Type",
+	RangeNotSatisfiable:         "Range Not Satisfiable",
+	ExpectationFailed:           "Expectation Failed",
+	UnprocessableContent:        "Unprocessable Content",
+	TooManyRequests:             "Too Many Requests",
+	RequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+
+	InternalServerError:     "Internal Server Error",
+	NotImplemented:          "Not Implemented",
+	BadGateway:              "Bad Gateway",
+	ServiceUnavailable:      "Service Unavailable",
+	GatewayTimeout:          "Gateway Timeout",
+	HTTPVersionNotSupported: "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase of the status code, or an empty string
+// if the code is unknown
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
+
+var (
+	ErrHijacked       = fmt.Errorf("response: connection has been hijacked")
+	ErrNotHijackable  = fmt.Errorf("response: writer does not support hijacking")
+	ErrHeadersWritten = fmt.Errorf("response: headers already written")
+)
+
+// Hijacker takes over the connection underneath a Writer, it returns the
+// connection and the bytes which were already read from it but not consumed
+type Hijacker func() (net.Conn, []byte, error)
+
+// ErrorWriter writes a complete response for an error, the server installs
+// one rendering its error pages
+type ErrorWriter func(w *Writer, statusCode StatusCode, err error)
+
+// HeaderHook is called by WriteHeaders with the status of the response and its
+// header fields before they are written, it may change them
+type HeaderHook func(statusCode StatusCode, h *headers.Headers)
+
+// Encoder wraps the destination of a body with a content coding, such as
+// gzip.NewWriter. Closing the returned writer must flush the encoded body
+type Encoder func(dst io.Writer) io.WriteCloser
+
 type Writer struct {
 	wr io.Writer
+	// err is the first error returned by wr
+	err     error
+	written int64
+
+	hijacker    Hijacker
+	hijacked    bool
+	errorWriter ErrorWriter
+	// chunked is set once headers announcing a chunked body are written
+	chunked bool
+
+	statusCode   StatusCode
+	hooks        []HeaderHook
+	wroteHeaders bool
+
+	// encoder is set by a header hook, once the headers are written the body
+	// goes through enc and its output is framed in chunks by buf
+	encoder     Encoder
+	enc         io.WriteCloser
+	buf         *bufio.Writer
+	bodyDone    bool
+	trailerDone bool
+
+	// the response held by Hold, written by Unhold
+	hold        holdState
+	holdMax     int
+	holdWant    func(StatusCode, *headers.Headers) bool
+	heldStatus  StatusCode
+	heldHeaders *headers.Headers
+	heldBody    []byte
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -26,33 +173,268 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+// SetHijacker makes the writer hijackable, it is called by the server which
+// owns the connection
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack lets the caller take over the connection. After a successful call the
+// writer rejects further writes and the caller is responsible for closing the
+// connection
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+
+	conn, buffered, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.DiscardHeld()
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether the connection has been taken over by Hijack
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+// Write writes p as is, except for the body of an encoded response, see
+// SetEncoder, which is encoded and framed
 func (w *Writer) Write(p []byte) (int, error) {
-	return w.wr.Write(p)
+	if w.hold != holdNone {
+		return w.holdWrite(p)
+	}
+	if w.encoding() {
+		return w.enc.Write(p)
+	}
+	return w.write(p)
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) (int, error) {
-	var err error
-	var n int
-	switch statusCode {
-	case OK:
-		n, err = w.Write([]byte("HTTP/1.1 200 OK\r\n"))
-	case BadRequest:
-		n, err = w.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-	case InternalServerError:
-		n, err = w.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-	default:
-		n, err = w.Write([]byte(fmt.Sprintf("HTTP/1.1 %d \r\n", statusCode)))
+func (w *Writer) write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	n, err := w.wr.Write(p)
+	w.written += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
 	}
+	return n, err
+}
 
+// ReadFrom writes the body read from r. On a connection such as *net.TCPConn
+// the copy is left to the kernel: sendfile when r is an *os.File, possibly
+// limited by an *io.LimitedReader, and splice from sockets. Other writers,
+// like TLS connections, get a buffered copy, which is also used to frame each
+// read as a chunk when the headers announced a chunked body
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.hold != holdNone {
+		// once the hold gives up the rest goes the usual way
+		n, err := w.holdFrom(r)
+		if err != nil || w.hold != holdNone {
+			return n, err
+		}
+		m, err := w.ReadFrom(r)
+		return n + m, err
+	}
+	if w.encoding() {
+		return io.Copy(writerOnly{w}, r)
+	}
+	if w.chunked {
+		return w.readChunksFrom(r)
+	}
+
+	rf, ok := w.wr.(io.ReaderFrom)
+	if !ok {
+		// hide ReadFrom from io.Copy, it would call back this method
+		return io.Copy(writerOnly{w}, r)
+	}
+	n, err := rf.ReadFrom(r)
+	w.written += n
+	if err != nil && w.err == nil {
+		w.err = err
+	}
 	return n, err
 }
 
+func (w *Writer) readChunksFrom(r io.Reader) (int64, error) {
+	p := make([]byte, 32<<10)
+	var n int64
+	for {
+		rn, rerr := r.Read(p)
+		if rn > 0 {
+			if _, err := w.WriteChunkedBody(p[:rn]); err != nil {
+				return n, err
+			}
+			n += int64(rn)
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+type writerOnly struct {
+	io.Writer
+}
+
+// Written returns the number of bytes written so far
+func (w *Writer) Written() int64 {
+	return w.written
+}
+
+// SetErrorWriter sets the function writing the responses of WriteError
+func (w *Writer) SetErrorWriter(ew ErrorWriter) {
+	w.errorWriter = ew
+}
+
+// WriteError writes a complete response for the error with the error writer
+// set on w, or as plain text when there is none. It returns the first error
+// met while writing to the underlying writer
+func (w *Writer) WriteError(statusCode StatusCode, err error) error {
+	if w.errorWriter != nil {
+		w.errorWriter(w, statusCode, err)
+		return w.err
+	}
+
+	body := err.Error()
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+	return w.err
+}
+
+// OnWriteHeaders adds a hook run by the next WriteHeaders, hooks are run in the
+// order they were added. Middlewares use them to change the responses of the
+// handlers they wrap
+func (w *Writer) OnWriteHeaders(hook HeaderHook) {
+	w.hooks = append(w.hooks, hook)
+}
+
+// SetCookie adds a Set-Cookie line to the headers written next, each cookie
+// gets a line of its own. It fails when the cookie is invalid or the headers
+// are already written
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.wroteHeaders {
+		return ErrHeadersWritten
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	line := c.String()
+	w.OnWriteHeaders(func(statusCode StatusCode, h *headers.Headers) {
+		h.Add("Set-Cookie", line)
+	})
+	return nil
+}
+
+// SetEncoder encodes the body of the response with enc, it is meant to be
+// called by a header hook which also sets Content-Encoding. The length of the
+// encoded body is unknown: WriteHeaders replaces Content-Length with a chunked
+// Transfer-Encoding and the body written with Write, WriteBody or
+// WriteChunkedBody is encoded then framed in chunks. The body ends with
+// WriteChunkedBodyDone, WriteTrailers or Finish
+func (w *Writer) SetEncoder(enc Encoder) {
+	w.encoder = enc
+}
+
+// StatusCode returns the status written by WriteStatusLine, 0 before
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+func (w *Writer) encoding() bool {
+	return w.enc != nil && !w.bodyDone
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) (int, error) {
+	if w.hold == holdHead && w.heldStatus == 0 && statusCode >= 200 && !w.hijacked {
+		w.heldStatus = statusCode
+		return len(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))), nil
+	}
+	if w.hold != holdNone {
+		w.Unhold()
+	}
+	if statusCode >= 200 {
+		// interim responses don't set the status of the response
+		w.statusCode = statusCode
+	}
+	return w.write([]byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))))
+}
+
 func (w *Writer) WriteHeaders(h *headers.Headers) (int, error) {
+	if w.hold == holdHead && w.heldStatus != 0 && w.holds(h) {
+		w.heldHeaders = h
+		w.hold = holdBody
+		return 0, nil
+	}
+	if w.hold != holdNone {
+		w.Unhold()
+	}
+	if !w.wroteHeaders && w.statusCode != 0 {
+		w.wroteHeaders = true
+		for _, hook := range w.hooks {
+			hook(w.statusCode, h)
+		}
+		if w.encoder != nil {
+			h.Delete("Content-Length")
+			if !isChunked(h) {
+				h.Set("Transfer-Encoding", "chunked")
+			}
+		}
+	}
+	w.chunked = isChunked(h)
+
+	n, err := w.writeFields(h)
+	if w.encoder != nil && w.enc == nil && w.wroteHeaders {
+		w.buf = bufio.NewWriterSize(chunkWriter{w}, 4<<10)
+		w.enc = w.encoder(w.buf)
+	}
+	return n, err
+}
+
+func isChunked(h *headers.Headers) bool {
+	te := h.Get("Transfer-Encoding")
+	if te == "" {
+		return false
+	}
+	codings := strings.Split(te, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// chunkWriter frames the output of an encoder in chunks
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := cw.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *Writer) writeFields(h *headers.Headers) (int, error) {
 	var err error
 	var n int
 	h.ForEach(func(key string, value string) {
 		headerstr := fmt.Sprintf("%s: %s\r\n", key, value)
-		wn, werr := w.Write([]byte(headerstr))
+		wn, werr := w.write([]byte(headerstr))
 		slog.Debug("Header", slog.String("header", headerstr))
 		n += wn
 		// only write the 1st error
@@ -61,8 +443,8 @@ func (w *Writer) WriteHeaders(h *headers.Headers) (int, error) {
 		}
 	})
 
-	rn, rerr := w.Write([]byte("\r\n"))
-	if err != nil {
+	rn, rerr := w.write([]byte("\r\n"))
+	if err == nil {
 		err = rerr
 	}
 
@@ -70,15 +452,7 @@ func (w *Writer) WriteHeaders(h *headers.Headers) (int, error) {
 }
 
 func (w *Writer) WriteBody(body []byte) (int, error) {
-	crn, err := w.Write([]byte("\r\n"))
-	if err != nil {
-		return 0, err
-	}
-	bn, err := w.Write(body)
-	if err != nil {
-		return crn, err
-	}
-	return crn + bn, nil
+	return w.Write(body)
 }
 
 func GetDefaultHeaders(contentLength int) *headers.Headers {
@@ -90,17 +464,27 @@ func GetDefaultHeaders(contentLength int) *headers.Headers {
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	n1, err := w.wr.Write([]byte(fmt.Sprintf("%x\r\n", len(p))))
+	if w.hold != holdNone {
+		w.Unhold()
+	}
+	if w.encoding() {
+		return w.enc.Write(p)
+	}
+	return w.writeChunk(p)
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	n1, err := w.write([]byte(fmt.Sprintf("%x\r\n", len(p))))
 	if err != nil {
 		return 0, err
 	}
 
-	n2, err := w.wr.Write(p)
+	n2, err := w.write(p)
 	if err != nil {
 		return n1, err
 	}
 
-	n3, err := w.wr.Write([]byte("\r\n"))
+	n3, err := w.write([]byte("\r\n"))
 	if err != nil {
 		return n1 + n2, err
 	}
@@ -108,7 +492,13 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	return n1 + n2 + n3, err
 }
 
+// WriteInternalServerError writes a 500 response with the error writer set on
+// w, or with the headers h and the error as body when there is none
 func (w *Writer) WriteInternalServerError(err error, h *headers.Headers) {
+	if w.errorWriter != nil {
+		w.errorWriter(w, InternalServerError, err)
+		return
+	}
 	body := err.Error()
 	h.Delete("Transfer-Encoding")
 	h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
@@ -117,6 +507,55 @@ func (w *Writer) WriteInternalServerError(err error, h *headers.Headers) {
 	w.WriteBody([]byte(body))
 }
 
+// WriteChunkedBodyDone writes the last chunk of a chunked body, the trailer
+// section written by WriteTrailers follows
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.hold != holdNone {
+		w.Unhold()
+	}
+	if w.encoding() {
+		w.bodyDone = true
+		err := w.enc.Close()
+		if err == nil {
+			err = w.buf.Flush()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	w.bodyDone = true
+	return w.write([]byte("0\r\n"))
+}
+
 func (w *Writer) WriteTrailers(h *headers.Headers) (int, error) {
-	return w.WriteHeaders(h)
+	if w.hold != holdNone {
+		w.Unhold()
+	}
+	if w.encoding() {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return 0, err
+		}
+	}
+	w.trailerDone = true
+	return w.writeFields(h)
+}
+
+// Finish ends the body of an encoded response when the handler didn't, with
+// the last chunk and an empty trailer section. It does nothing for other
+// responses and returns the first error met while writing
+func (w *Writer) Finish() error {
+	if w.hold != holdNone {
+		w.Unhold()
+	}
+	if w.enc == nil || w.hijacked {
+		return w.err
+	}
+	if !w.bodyDone {
+		w.WriteChunkedBodyDone()
+	}
+	if !w.trailerDone {
+		w.trailerDone = true
+		w.write([]byte("\r\n"))
+	}
+	return w.err
 }
@@ -0,0 +1,276 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/cookie"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterReadFrom(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteStatusLine(OK)
+	w.WriteHeaders(GetDefaultHeaders(11))
+	n, err := io.Copy(w, strings.NewReader("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello world"))
+	assert.Equal(t, int64(buf.Len()), w.Written())
+
+	// a chunked body frames every read as a chunk
+	buf.Reset()
+	w = NewWriter(&buf)
+	h := headers.NewHeaders()
+	h.Replace("Transfer-Encoding", "chunked")
+	w.WriteHeaders(h)
+	n, err = w.ReadFrom(io.MultiReader(strings.NewReader("hello "), strings.NewReader("world")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), n)
+	assert.Equal(t, "transfer-encoding: chunked\r\n\r\n6\r\nhello \r\n5\r\nworld\r\n", buf.String())
+
+	// writers without ReadFrom get a plain copy
+	buf.Reset()
+	w = NewWriter(struct{ io.Writer }{&buf})
+	n, err = w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, "hello", buf.String())
+
+	w.SetHijacker(func() (net.Conn, []byte, error) { return nil, nil, nil })
+	w.Hijack()
+	_, err = w.ReadFrom(strings.NewReader("hello"))
+	assert.ErrorIs(t, err, ErrHijacked)
+}
+
+// upperEncoder is a content coding easy to check
+type upperEncoder struct{ w io.Writer }
+
+func (e upperEncoder) Write(p []byte) (int, error) {
+	return e.w.Write(bytes.ToUpper(p))
+}
+
+func (e upperEncoder) Close() error {
+	_, err := e.w.Write([]byte("!"))
+	return err
+}
+
+func TestWriterEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.OnWriteHeaders(func(statusCode StatusCode, h *headers.Headers) {
+		assert.Equal(t, Created, statusCode)
+		h.Replace("Content-Encoding", "upper")
+		w.SetEncoder(func(dst io.Writer) io.WriteCloser { return upperEncoder{dst} })
+	})
+	w.WriteStatusLine(Created)
+	h := headers.NewHeaders()
+	h.Replace("Content-Length", "11")
+	w.WriteHeaders(h)
+	w.WriteBody([]byte("hello "))
+	io.Copy(w, strings.NewReader("world"))
+	require.NoError(t, w.Finish())
+	head, body, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.Contains(t, head+"\r\n", "content-encoding: upper\r\n")
+	assert.Contains(t, head+"\r\n", "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, head, "content-length")
+	assert.Equal(t, "c\r\nHELLO WORLD!\r\n0\r\n\r\n", body)
+	assert.Equal(t, Created, w.StatusCode())
+
+	// a chunked body with trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.OnWriteHeaders(func(statusCode StatusCode, h *headers.Headers) {
+		w.SetEncoder(func(dst io.Writer) io.WriteCloser { return upperEncoder{dst} })
+	})
+	w.WriteStatusLine(OK)
+	h = headers.NewHeaders()
+	h.Replace("Transfer-Encoding", "chunked")
+	w.WriteHeaders(h)
+	w.WriteChunkedBody([]byte("hello"))
+	w.WriteChunkedBodyDone()
+	trailers := headers.NewHeaders()
+	trailers.Replace("X-Sum", "1")
+	w.WriteTrailers(trailers)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n6\r\nHELLO!\r\n0\r\nx-sum: 1\r\n\r\n", buf.String())
+}
+
+func TestWriterHold(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	hooked := false
+	w.OnWriteHeaders(func(statusCode StatusCode, h *headers.Headers) { hooked = true })
+	w.Hold(10, nil)
+	w.WriteStatusLine(OK)
+	w.WriteHeaders(GetDefaultHeaders(5))
+	w.WriteBody([]byte("hello"))
+	assert.Zero(t, buf.Len())
+	assert.False(t, hooked)
+	status, h, body, ok := w.Held()
+	require.True(t, ok)
+	assert.Equal(t, OK, status)
+	assert.Equal(t, "hello", string(body))
+	h.Replace("X-Held", "1")
+	require.NoError(t, w.Unhold())
+	assert.True(t, hooked)
+	head, rest, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head+"\r\n", "x-held: 1\r\n")
+	assert.Equal(t, "hello", rest)
+
+	// a body over the limit is written through
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Hold(3, nil)
+	w.WriteStatusLine(OK)
+	w.WriteHeaders(GetDefaultHeaders(5))
+	w.WriteBody([]byte("hello"))
+	_, _, _, ok = w.Held()
+	assert.False(t, ok)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+
+	// so is a response the caller doesn't want or one announced too large
+	for _, want := range []func(StatusCode, *headers.Headers) bool{
+		func(StatusCode, *headers.Headers) bool { return false },
+		nil,
+	} {
+		buf.Reset()
+		w = NewWriter(&buf)
+		w.Hold(3, want)
+		w.WriteStatusLine(OK)
+		w.WriteHeaders(GetDefaultHeaders(5))
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	}
+
+	// bodies read from a reader are held too
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Hold(10, nil)
+	w.WriteStatusLine(OK)
+	w.WriteHeaders(headers.NewHeaders())
+	n, err := w.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	_, _, body, ok = w.Held()
+	require.True(t, ok)
+	assert.Equal(t, "hello", string(body))
+	assert.Zero(t, buf.Len())
+
+	// discarded responses are replaced
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Hold(10, nil)
+	w.WriteStatusLine(OK)
+	w.WriteHeaders(GetDefaultHeaders(5))
+	w.WriteBody([]byte("hello"))
+	w.DiscardHeld()
+	w.WriteStatusLine(NotModified)
+	w.WriteHeaders(headers.NewHeaders())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", buf.String())
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "id", Value: "1", Path: "/", HttpOnly: true}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "theme", Value: "dark"}))
+	assert.ErrorIs(t, w.SetCookie(&cookie.Cookie{Name: "bad name"}), cookie.ErrInvalidName)
+	w.WriteStatusLine(OK)
+	w.WriteHeaders(GetDefaultHeaders(0))
+	assert.ErrorIs(t, w.SetCookie(&cookie.Cookie{Name: "late"}), ErrHeadersWritten)
+
+	res, err := ResponseFromReader(&buf, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id=1; Path=/; HttpOnly", "theme=dark"}, res.Headers.Values("Set-Cookie"))
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t testing.TB) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	server := <-accepted
+	require.NotNil(t, server)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server.(*net.TCPConn), client.(*net.TCPConn)
+}
+
+func writeTempFile(t testing.TB, size int) *os.File {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "body")
+	require.NoError(t, os.WriteFile(name, bytes.Repeat([]byte("0123456789abcdef"), size/16), 0o644))
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestWriterReadFromTCP(t *testing.T) {
+	f := writeTempFile(t, 1<<20)
+	server, client := tcpPair(t)
+
+	received := make(chan []byte, 1)
+	go func() {
+		p, _ := io.ReadAll(client)
+		received <- p
+	}()
+
+	w := NewWriter(server)
+	f.Seek(16, io.SeekStart)
+	n, err := io.Copy(w, io.LimitReader(f, 32))
+	require.NoError(t, err)
+	assert.Equal(t, int64(32), n)
+	server.CloseWrite()
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", string(<-received))
+	assert.Equal(t, int64(32), w.Written())
+}
+
+func BenchmarkWriterReadFrom(b *testing.B) {
+	const size = 16 << 20
+	f := writeTempFile(b, size)
+
+	for _, bc := range []struct {
+		name string
+		wrap func(*Writer) io.Writer
+	}{
+		// io.Copy uses Writer.ReadFrom, sendfile on linux
+		{"sendfile", func(w *Writer) io.Writer { return w }},
+		// hiding ReadFrom copies through a user space buffer
+		{"copy", func(w *Writer) io.Writer { return writerOnly{w} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server, client := tcpPair(b)
+			go io.Copy(io.Discard, client)
+			w := bc.wrap(NewWriter(server))
+
+			b.ReportAllocs()
+			b.SetBytes(size)
+			for b.Loop() {
+				f.Seek(0, io.SeekStart)
+				if _, err := io.Copy(w, f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
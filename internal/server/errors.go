@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/negotiate"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+)
+
+// ErrorHandler writes the response for an error. req is nil when the error is
+// a request which could not be parsed
+type ErrorHandler func(w *response.Writer, req *request.Request, status response.StatusCode, err error)
+
+// TextErrorHandler writes the message of the error as plain text, a Problem is
+// written as application/problem+json
+func TextErrorHandler(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	var p *Problem
+	if errors.As(err, &p) {
+		writeProblem(w, status, p)
+		return
+	}
+	writeError(w, status, "text/plain", []byte(errorMessage(err)))
+}
+
+// ErrorPage is the data error pages are rendered from
+type ErrorPage struct {
+	StatusCode response.StatusCode
+	StatusText string
+	Message    string
+}
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<html>
+  <head>
+    <title>{{.StatusCode}} {{.StatusText}}</title>
+  </head>
+  <body>
+    <h1>{{.StatusText}}</h1>
+    <p>{{.Message}}</p>
+  </body>
+</html>`))
+
+// ErrorPages renders errors as HTML, JSON or plain text, whichever the Accept
+// header of the request prefers. Every error is rendered as problem details
+// when the request prefers application/problem+json, as are Problems when it
+// prefers application/json. Under HTML or plain text a Problem shows its
+// detail, or its title when it has none
+type ErrorPages struct {
+	// HTML is executed with an ErrorPage, a minimal page is used when nil
+	HTML *template.Template
+	// Message returns the message shown for the error. By default it is the
+	// message of a HandlerError, the status text for other server errors and
+	// the error string otherwise
+	Message func(status response.StatusCode, err error) string
+	// Default is the media type used when the request is unknown, it is
+	// also preferred on ties. It defaults to text/plain
+	Default string
+}
+
+// Handle is the ErrorHandler of the pages
+func (p ErrorPages) Handle(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+	page := ErrorPage{
+		StatusCode: status,
+		StatusText: response.StatusText(status),
+	}
+	if p.Message != nil {
+		page.Message = p.Message(status, err)
+	} else if status >= 500 && !isHandlerError(err) {
+		// don't leak the internals of the server
+		page.Message = page.StatusText
+	} else {
+		page.Message = errorMessage(err)
+	}
+
+	var problem *Problem
+	isProblem := errors.As(err, &problem)
+	offers := []string{"text/plain", "text/html", "application/json", "application/problem+json"}
+	if p.Default != "" {
+		offers = append([]string{p.Default}, offers...)
+	}
+	mediaType := offers[0]
+	if req != nil {
+		// nothing acceptable falls back to text/plain below
+		mediaType, _ = negotiate.Negotiate(req, offers)
+		// caches must not serve the page to clients accepting another type
+		w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+			h.Set("Vary", "Accept")
+		})
+	}
+
+	var body []byte
+	switch mediaType {
+	case "text/html":
+		tmpl := p.HTML
+		if tmpl == nil {
+			tmpl = defaultErrorTemplate
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, page); err != nil {
+			writeError(w, status, "text/plain", []byte(page.Message))
+			return
+		}
+		body = buf.Bytes()
+		mediaType = "text/html; charset=utf-8"
+	case "application/problem+json":
+		writeProblem(w, status, problemFor(status, err))
+		return
+	case "application/json":
+		if isProblem {
+			writeProblem(w, status, problem)
+			return
+		}
+		body, _ = json.Marshal(struct {
+			Status  int    `json:"status"`
+			Title   string `json:"title"`
+			Message string `json:"message"`
+		}{int(status), page.StatusText, page.Message})
+	default:
+		// nothing acceptable, an error page is better than a 406
+		body = []byte(page.Message)
+		mediaType = "text/plain"
+	}
+	writeError(w, status, mediaType, body)
+}
+
+func writeError(w *response.Writer, status response.StatusCode, contentType string, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", contentType)
+	w.WriteStatusLine(status)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func isHandlerError(err error) bool {
+	var he *HandlerError
+	var p *Problem
+	return errors.As(err, &he) || errors.As(err, &p)
+}
+
+func errorMessage(err error) string {
+	var he *HandlerError
+	if errors.As(err, &he) {
+		return he.Message
+	}
+	var p *Problem
+	if errors.As(err, &p) {
+		if p.Detail != "" {
+			return p.Detail
+		}
+		return p.Title
+	}
+	return err.Error()
+}
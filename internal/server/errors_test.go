@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordError(t *testing.T, eh ErrorHandler, req *request.Request, status response.StatusCode, err error) (*response.Response, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	eh(response.NewWriter(&buf), req, status, err)
+	res, rerr := response.ResponseFromReader(&buf, "GET")
+	require.NoError(t, rerr)
+	body, rerr := io.ReadAll(res.Body)
+	require.NoError(t, rerr)
+	return res, string(body)
+}
+
+func acceptRequest(accept string) *request.Request {
+	h := headers.NewHeaders()
+	h.Replace("Accept", accept)
+	return &request.Request{Headers: h}
+}
+
+func TestErrorPages(t *testing.T) {
+	pages := ErrorPages{}.Handle
+
+	res, body := recordError(t, pages, acceptRequest("text/html"), response.NotFound, NewHandlerError(response.NotFound, "no such <page>"))
+	assert.Equal(t, response.NotFound, res.StatusLine.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers.Get("Content-Type"))
+	assert.Contains(t, body, "<title>404 Not Found</title>")
+	assert.Contains(t, body, "no such &lt;page&gt;")
+	assert.Equal(t, "Accept", res.Headers.Get("Vary"))
+
+	res, body = recordError(t, pages, acceptRequest("application/json"), response.BadRequest, fmt.Errorf("bad input"))
+	assert.Equal(t, "application/json", res.Headers.Get("Content-Type"))
+	var page map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	assert.Equal(t, map[string]any{"status": 400.0, "title": "Bad Request", "message": "bad input"}, page)
+
+	// internal errors are not exposed
+	res, body = recordError(t, pages, nil, response.InternalServerError, fmt.Errorf("db password rejected"))
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "Internal Server Error", body)
+	assert.Empty(t, res.Headers.Get("Vary"))
+
+	// problems are problem details only for JSON clients
+	res, body = recordError(t, pages, acceptRequest("text/plain"), response.Conflict, NewProblem(response.Conflict, "version mismatch"))
+	assert.Equal(t, "text/plain", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "version mismatch", body)
+	res, _ = recordError(t, pages, acceptRequest("application/json"), response.Conflict, NewProblem(response.Conflict, "version mismatch"))
+	assert.Equal(t, "application/problem+json", res.Headers.Get("Content-Type"))
+
+	custom := ErrorPages{
+		HTML:    template.Must(template.New("").Parse(`<p>{{.StatusCode}}: {{.Message}}</p>`)),
+		Message: func(status response.StatusCode, err error) string { return "oops" },
+		Default: "text/html",
+	}
+	res, body = recordError(t, custom.Handle, nil, response.BadRequest, fmt.Errorf("bad input"))
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "<p>400: oops</p>", body)
+}
+
+func TestServerErrorHandler(t *testing.T) {
+	type call struct {
+		req    *request.Request
+		status response.StatusCode
+		err    error
+	}
+	calls := make(chan call, 1)
+	eh := func(w *response.Writer, req *request.Request, status response.StatusCode, err error) {
+		calls <- call{req, status, err}
+		ErrorPages{}.Handle(w, req, status, err)
+	}
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		NewHandlerError(response.Forbidden, "keep out").WriteTo(w)
+	}, WithErrorHandler(eh), WithLimits(request.Limits{MaxHeaderBytes: 64}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	send := func(raw string) string {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		p, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(p)
+	}
+
+	res := send("GET / HTTP/1.1\r\nAccept: application/json\r\n\r\n")
+	c := <-calls
+	assert.NotNil(t, c.req)
+	assert.Equal(t, response.Forbidden, c.status)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 403 Forbidden\r\n"), res)
+	assert.True(t, strings.HasSuffix(res, `{"status":403,"title":"Forbidden","message":"keep out"}`), res)
+
+	res = send("GET / HTTP/1.1\r\nX-Large: " + strings.Repeat("a", 100) + "\r\n\r\n")
+	c = <-calls
+	assert.Nil(t, c.req)
+	assert.Equal(t, response.RequestHeaderFieldsTooLarge, c.status)
+	assert.ErrorIs(t, c.err, request.ErrRequestHeadersTooLarge)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 431 "), res)
+}
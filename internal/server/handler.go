@@ -9,6 +9,17 @@ import (
 
 type Handler func(w *response.Writer, req *request.Request)
 
+// Middleware wraps a handler with behaviour shared by several handlers
+type Middleware func(h Handler) Handler
+
+// Chain wraps h with the middlewares, the first one is the outermost
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
@@ -25,19 +36,10 @@ func (h *HandlerError) Error() string {
 	return fmt.Sprintf("status code: %d, message = %s", h.StatusCode, h.Message)
 }
 
+// WriteTo writes the error with the server's error handler, it returns the
+// number of bytes written
 func (h *HandlerError) WriteTo(w *response.Writer) (int64, error) {
-	sln, err := w.WriteStatusLine(h.StatusCode)
-	if err != nil {
-		return 0, err
-	}
-	hn, err := w.WriteHeaders(response.GetDefaultHeaders(len(h.Message)))
-	if err != nil {
-		return int64(sln), err
-	}
-	bn, err := w.WriteBody([]byte(h.Message))
-	if err != nil {
-		return int64(sln + hn), err
-	}
-
-	return int64(sln + hn + bn), nil
+	n := w.Written()
+	err := w.WriteError(h.StatusCode, h)
+	return w.Written() - n, err
 }
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+)
+
+// DefaultMaxJSONBytes bounds the bodies decoded by DecodeJSON
+const DefaultMaxJSONBytes = 1 << 20
+
+// Problem is an RFC 9457 problem details object. Writing one with WriteTo or
+// Writer.WriteError renders it as application/problem+json
+type Problem struct {
+	// Type is a URI reference identifying the problem type, about:blank when
+	// empty
+	Type     string
+	Status   response.StatusCode
+	Title    string
+	Detail   string
+	Instance string
+	// Extensions are additional members, they can't override the ones above
+	Extensions map[string]any
+}
+
+// NewProblem creates a problem of type about:blank, whose title is the status
+// text
+func NewProblem(statusCode response.StatusCode, detail string) *Problem {
+	return &Problem{
+		Status: statusCode,
+		Title:  response.StatusText(statusCode),
+		Detail: detail,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("status code: %d, title = %s", p.Status, p.Title)
+	}
+	return fmt.Sprintf("status code: %d, title = %s, detail = %s", p.Status, p.Title, p.Detail)
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	} else {
+		delete(m, "type")
+	}
+	m["status"] = int(p.Status)
+	m["title"] = p.Title
+	delete(m, "detail")
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	delete(m, "instance")
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// WriteTo writes the problem with the server's error handler, it returns the
+// number of bytes written
+func (p *Problem) WriteTo(w *response.Writer) (int64, error) {
+	n := w.Written()
+	err := w.WriteError(p.Status, p)
+	return w.Written() - n, err
+}
+
+// Problem returns the problem details of the error
+func (h *HandlerError) Problem() *Problem {
+	return NewProblem(h.StatusCode, h.Message)
+}
+
+// problemFor returns the problem details of an error written with the status,
+// the details of errors other than Problem and HandlerError are not exposed
+// for server errors
+func problemFor(status response.StatusCode, err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	var he *HandlerError
+	if errors.As(err, &he) {
+		return he.Problem()
+	}
+	if status >= 500 {
+		return NewProblem(status, "")
+	}
+	return NewProblem(status, err.Error())
+}
+
+func writeProblem(w *response.Writer, status response.StatusCode, p *Problem) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		body, _ = json.Marshal(NewProblem(status, ""))
+	}
+	writeError(w, status, "application/problem+json", body)
+}
+
+// WriteJSON writes a complete response whose body is v encoded as JSON. When
+// v can't be encoded nothing is written and the error is returned, the
+// handler is expected to respond with a server error
+func WriteJSON(w *response.Writer, statusCode response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "application/json")
+	if _, err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if _, err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// JSONDecoder decodes JSON request bodies, the zero value is strict
+type JSONDecoder struct {
+	// MaxBytes bounds the body, DefaultMaxJSONBytes when 0
+	MaxBytes int64
+	// AllowUnknownFields accepts object keys matching no field of the
+	// destination struct
+	AllowUnknownFields bool
+	// AllowTrailingData accepts bytes after the JSON value
+	AllowTrailingData bool
+}
+
+// DecodeJSON decodes the body of the request into v with a strict
+// JSONDecoder, see JSONDecoder.Decode
+func DecodeJSON(req *request.Request, v any) error {
+	return JSONDecoder{}.Decode(req, v)
+}
+
+// Decode decodes the body of the request into v. The error is a *Problem: 415
+// when the Content-Type isn't JSON, 413 when the body is too large and 400
+// when it isn't valid for v, with the offset or the field in error as
+// extension members. The handler can write it as is with Problem.WriteTo
+func (d JSONDecoder) Decode(req *request.Request, v any) error {
+	maxBytes := d.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxJSONBytes
+	}
+
+	if !isJSON(req.Headers.Get("Content-Type")) {
+		return NewProblem(response.UnsupportedMediaType, "content-type must be application/json")
+	}
+	if int64(len(req.Body)) > maxBytes {
+		return NewProblem(response.ContentTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxBytes))
+	}
+	if len(bytes.TrimSpace(req.Body)) == 0 {
+		return NewProblem(response.BadRequest, "body must not be empty")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	if !d.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return decodeProblem(err)
+	}
+	if !d.AllowTrailingData {
+		if _, err := dec.Token(); err != io.EOF {
+			p := NewProblem(response.BadRequest, "body must contain a single JSON value")
+			p.Extensions = map[string]any{"offset": dec.InputOffset()}
+			return p
+		}
+	}
+	return nil
+}
+
+func decodeProblem(err error) *Problem {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		p := NewProblem(response.BadRequest, "malformed JSON: "+syntaxErr.Error())
+		p.Extensions = map[string]any{"offset": syntaxErr.Offset}
+		return p
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return NewProblem(response.BadRequest, "malformed JSON: unexpected end of body")
+	case errors.As(err, &typeErr):
+		p := NewProblem(response.BadRequest, fmt.Sprintf("%s must be of type %s", fieldName(typeErr.Field), typeErr.Type))
+		p.Extensions = map[string]any{"field": typeErr.Field, "offset": typeErr.Offset}
+		return p
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder has no error type for unknown fields, its message is
+		// pinned by TestUnknownFieldMessage
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		p := NewProblem(response.BadRequest, fmt.Sprintf("unknown field %q", field))
+		p.Extensions = map[string]any{"field": field}
+		return p
+	default:
+		return NewProblem(response.BadRequest, err.Error())
+	}
+}
+
+func fieldName(field string) string {
+	if field == "" {
+		return "body"
+	}
+	return fmt.Sprintf("field %q", field)
+}
+
+// isJSON reports whether the media type is application/json or a +json one
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")
+}
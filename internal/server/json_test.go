@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonRequest(contentType, body string) *request.Request {
+	h := headers.NewHeaders()
+	if contentType != "" {
+		h.Replace("Content-Type", contentType)
+	}
+	return &request.Request{Headers: h, Body: []byte(body)}
+}
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var u user
+	require.NoError(t, DecodeJSON(jsonRequest("application/json; charset=utf-8", `{"name":"jane","age":42}`), &u))
+	assert.Equal(t, user{"jane", 42}, u)
+	require.NoError(t, DecodeJSON(jsonRequest("application/merge-patch+json", ` {"age":43} `+"\n"), &u))
+	assert.Equal(t, user{"jane", 43}, u)
+
+	tests := []struct {
+		description string
+		contentType string
+		body        string
+		status      response.StatusCode
+		detail      string
+		extensions  map[string]any
+	}{
+		{"missing content-type", "", `{}`, 415, "content-type must be application/json", nil},
+		{"not json", "text/plain", `{}`, 415, "content-type must be application/json", nil},
+		{"empty", "application/json", " ", 400, "body must not be empty", nil},
+		{"syntax", "application/json", `{"name":}`, 400, "malformed JSON: invalid character '}' looking for beginning of value", map[string]any{"offset": int64(9)}},
+		{"truncated", "application/json", `{"name":"jane"`, 400, "malformed JSON: unexpected end of body", nil},
+		{"type", "application/json", `{"age":"old"}`, 400, `field "age" must be of type int`, map[string]any{"field": "age", "offset": int64(12)}},
+		{"unknown field", "application/json", `{"nmae":"jane"}`, 400, `unknown field "nmae"`, map[string]any{"field": "nmae"}},
+		{"trailing data", "application/json", `{"name":"jane"} {}`, 400, "body must contain a single JSON value", map[string]any{"offset": int64(17)}},
+		{"too large", "application/json", `{"name":"` + string(bytes.Repeat([]byte("a"), DefaultMaxJSONBytes)) + `"}`, 413, fmt.Sprintf("body must not be larger than %d bytes", DefaultMaxJSONBytes), nil},
+	}
+	for _, tt := range tests {
+		err := DecodeJSON(jsonRequest(tt.contentType, tt.body), &user{})
+		var p *Problem
+		require.ErrorAs(t, err, &p, tt.description)
+		assert.Equal(t, tt.status, p.Status, tt.description)
+		assert.Equal(t, response.StatusText(tt.status), p.Title, tt.description)
+		assert.Equal(t, tt.detail, p.Detail, tt.description)
+		assert.Equal(t, tt.extensions, p.Extensions, tt.description)
+	}
+
+	lenient := JSONDecoder{AllowUnknownFields: true, AllowTrailingData: true, MaxBytes: 64}
+	require.NoError(t, lenient.Decode(jsonRequest("application/json", `{"nmae":"jane","age":1} garbage`), &u))
+	assert.Equal(t, 1, u.Age)
+	err := lenient.Decode(jsonRequest("application/json", string(bytes.Repeat([]byte(" "), 65))), &u)
+	assert.Equal(t, response.ContentTooLarge, err.(*Problem).Status)
+}
+
+// TestUnknownFieldMessage fails when encoding/json changes the message
+// decodeProblem recognizes unknown fields by
+func TestUnknownFieldMessage(t *testing.T) {
+	d := json.NewDecoder(strings.NewReader(`{"nmae":"jane"}`))
+	d.DisallowUnknownFields()
+	err := d.Decode(&user{})
+	require.Error(t, err)
+	assert.Equal(t, `json: unknown field "nmae"`, err.Error())
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteJSON(response.NewWriter(&buf), response.Created, user{"jane", 42}))
+	res, err := response.ResponseFromReader(&buf, "POST")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, response.Created, res.StatusLine.StatusCode)
+	assert.Equal(t, "application/json", res.Headers.Get("Content-Type"))
+	assert.Equal(t, "24", res.Headers.Get("Content-Length"))
+	assert.Equal(t, `{"name":"jane","age":42}`, string(body))
+
+	buf.Reset()
+	assert.Error(t, WriteJSON(response.NewWriter(&buf), response.OK, func() {}))
+	assert.Zero(t, buf.Len())
+}
+
+func TestProblem(t *testing.T) {
+	p := &Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     response.Forbidden,
+		Title:      "You do not have enough credit.",
+		Detail:     "Your current balance is 30, but that costs 50.",
+		Instance:   "/account/12345/msgs/abc",
+		Extensions: map[string]any{"balance": 30, "status": "ignored"},
+	}
+	body, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-credit",
+		"status": 403,
+		"title": "You do not have enough credit.",
+		"detail": "Your current balance is 30, but that costs 50.",
+		"instance": "/account/12345/msgs/abc",
+		"balance": 30
+	}`, string(body))
+
+	body, err = json.Marshal(NewHandlerError(response.NotFound, "no such user").Problem())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":404,"title":"Not Found","detail":"no such user"}`, string(body))
+
+	// the default error handler writes problems as problem+json
+	res, body2 := recordError(t, TextErrorHandler, nil, response.Forbidden, p)
+	assert.Equal(t, "application/problem+json", res.Headers.Get("Content-Type"))
+	assert.Contains(t, body2, `"balance":30`)
+
+	// error pages render problems for JSON clients
+	pages := ErrorPages{}.Handle
+	res, body2 = recordError(t, pages, acceptRequest("application/json"), response.Forbidden, p)
+	assert.Equal(t, "application/problem+json", res.Headers.Get("Content-Type"))
+	assert.Contains(t, body2, `"balance":30`)
+	res, body2 = recordError(t, pages, acceptRequest("text/html"), response.Forbidden, p)
+	assert.Equal(t, "text/html; charset=utf-8", res.Headers.Get("Content-Type"))
+	assert.Contains(t, body2, "Your current balance is 30, but that costs 50.")
+
+	// and every error for problem+json clients, hiding server errors
+	res, body2 = recordError(t, pages, acceptRequest("application/problem+json"), response.InternalServerError, fmt.Errorf("db password rejected"))
+	assert.Equal(t, "application/problem+json", res.Headers.Get("Content-Type"))
+	assert.JSONEq(t, `{"status":500,"title":"Internal Server Error"}`, body2)
+	_, body2 = recordError(t, pages, acceptRequest("application/problem+json"), response.BadRequest, NewHandlerError(response.BadRequest, "bad input"))
+	assert.JSONEq(t, `{"status":400,"title":"Bad Request","detail":"bad input"}`, body2)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -12,8 +13,10 @@ import (
 )
 
 type Server struct {
-	h        Handler
-	listener net.Listener
+	h            Handler
+	errorHandler ErrorHandler
+	limits       request.Limits
+	listener     net.Listener
 
 	mu          sync.RWMutex
 	connections map[net.Conn]struct{}
@@ -21,43 +24,80 @@ type Server struct {
 	closed atomic.Bool
 }
 
+// Option configures a Server
+type Option func(*Server)
+
+// WithErrorHandler sets the handler writing the responses of requests which
+// can't be parsed and of Writer.WriteError calls, TextErrorHandler by default
+func WithErrorHandler(eh ErrorHandler) Option {
+	return func(s *Server) {
+		s.errorHandler = eh
+	}
+}
+
+// WithLimits bounds the size of the requests, see request.Limits
+func WithLimits(l request.Limits) Option {
+	return func(s *Server) {
+		s.limits = l
+	}
+}
+
 // Serve create a net.Listener and returns a new Server instance. It also start
 // listening for requests in a goroutine
-func Serve(port int, h Handler) (*Server, error) {
+func Serve(port int, h Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
 
+	return ServeListener(listener, h, opts...), nil
+}
+
+// ServeListener returns a new Server accepting connections from the listener,
+// it is useful to control the address the server binds to
+func ServeListener(listener net.Listener, h Handler, opts ...Option) *Server {
 	s := &Server{
-		listener:    listener,
-		connections: map[net.Conn]struct{}{},
-		closed:      atomic.Bool{},
-		h:           h,
+		listener:     listener,
+		connections:  map[net.Conn]struct{}{},
+		closed:       atomic.Bool{},
+		h:            h,
+		errorHandler: TextErrorHandler,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	go s.listen()
-	return s, nil
+	return s
 }
 
 // Close close the listeners and the server
 func (s *Server) Close() error {
+	s.closed.Store(true)
+
 	err := s.listener.Close()
 	if err != nil {
 		slog.Error("failed to close listener", slog.Any("err", err))
 	}
 
-	s.closed.Store(true)
-
 	return nil
 }
 
+// Addr returns the listener's network address
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 // listen uses a loop to accept new connections as they come in and handle each
 // one in a new goroutine
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
+			if s.closed.Load() {
+				return
+			}
 			slog.Error("failed to accept connection", slog.Any("err", err))
+			continue
 		}
 		s.mu.Lock()
 		s.connections[conn] = struct{}{}
@@ -66,24 +106,44 @@ func (s *Server) listen() {
 	}
 }
 
-// handle handles a single connection and then closes the connection
+// handle handles a single connection and then closes the connection, unless
+// the handler has hijacked it
 func (s *Server) handle(conn net.Conn) {
+	w := response.NewWriter(conn)
 	defer func() {
+		if w.Hijacked() {
+			return
+		}
 		conn.Close()
-		s.mu.Lock()
-		delete(s.connections, conn)
-		s.mu.Unlock()
+		s.untrack(conn)
 	}()
 
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
+	req, err := request.RequestFromReaderLimits(conn, s.limits)
 	if err != nil {
-		body := err.Error()
-		w.WriteStatusLine(response.BadRequest)
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody([]byte(body))
+		status := response.BadRequest
+		var pe *request.ParseError
+		if errors.As(err, &pe) {
+			status = response.StatusCode(pe.StatusCode)
+		}
+		s.errorHandler(w, nil, status, err)
 		return
 	}
 
+	defer req.RemoveTempFiles()
+	req.RemoteAddr = conn.RemoteAddr().String()
+	w.SetErrorWriter(func(w *response.Writer, status response.StatusCode, err error) {
+		s.errorHandler(w, req, status, err)
+	})
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		s.untrack(conn)
+		return conn, req.Buffered(), nil
+	})
 	s.h(w, req)
 }
+
+// untrack removes the connection from the server's bookkeeping
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.connections, conn)
+	s.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerHijack(t *testing.T) {
+	type result struct {
+		connections int
+		writeErr    error
+	}
+	results := make(chan result, 1)
+
+	servers := make(chan *Server, 1)
+	h := func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		s := <-servers
+		s.mu.RLock()
+		connections := len(s.connections)
+		s.mu.RUnlock()
+		_, werr := w.WriteStatusLine(response.OK)
+		results <- result{connections: connections, writeErr: werr}
+
+		// echo everything the client sent after the request, starting with the
+		// bytes the server has already read
+		go func() {
+			defer conn.Close()
+			conn.Write(buffered)
+			io.Copy(conn, conn)
+		}()
+	}
+
+	s, err := Serve(0, h)
+	require.NoError(t, err)
+	defer s.Close()
+	servers <- s
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\n\r\nping"))
+	require.NoError(t, err)
+
+	res := <-results
+	assert.Equal(t, 0, res.connections)
+	assert.ErrorIs(t, res.writeErr, response.ErrHijacked)
+
+	p := make([]byte, 4)
+	_, err = io.ReadFull(conn, p)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(p))
+
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, p)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(p))
+}
+
+func TestServerHijackTwice(t *testing.T) {
+	w := response.NewWriter(io.Discard)
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+
+	w.SetHijacker(func() (net.Conn, []byte, error) {
+		return nil, nil, nil
+	})
+	_, _, err = w.Hijack()
+	require.NoError(t, err)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, response.ErrHijacked)
+}
+
+func TestServerParseErrorStatus(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		t.Error("handler called for an invalid request")
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	tests := []struct {
+		description string
+		raw         string
+		expect      string
+	}{
+		{"malformed request line", "get / HTTP/1.1\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported\r\n"},
+		{"request line too long", "GET /" + strings.Repeat("a", request.DefaultMaxRequestLineBytes) + " HTTP/1.1\r\n\r\n", "HTTP/1.1 414 URI Too Long\r\n"},
+		{"unsupported transfer-encoding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", "HTTP/1.1 501 Not Implemented\r\n"},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err, tt.description)
+		_, err = conn.Write([]byte(tt.raw))
+		require.NoError(t, err, tt.description)
+		p, err := io.ReadAll(conn)
+		conn.Close()
+		require.NoError(t, err, tt.description)
+		assert.True(t, strings.HasPrefix(string(p), tt.expect), "%s: %q", tt.description, p)
+	}
+}
+
+func TestServerRemovesTempFiles(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	done := make(chan int, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		_, err := req.FormFile("f")
+		assert.NoError(t, err)
+		entries, _ := os.ReadDir(tmp)
+		done <- len(entries)
+		w.WriteStatusLine(response.NoContent)
+		w.WriteHeaders(headers.NewHeaders())
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	body := "--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"f.txt\"\r\n\r\n" +
+		strings.Repeat("x", request.DefaultMaxFormMemory+1) + "\r\n--b--\r\n"
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, <-done)
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
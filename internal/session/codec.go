@@ -0,0 +1,139 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidKey   = fmt.Errorf("session: invalid key")
+	ErrInvalidValue = fmt.Errorf("session: invalid cookie value")
+)
+
+// Codec protects the values of cookies. The name of the cookie is bound to
+// the value so a value can't be moved to another cookie
+type Codec interface {
+	Encode(name string, p []byte) (string, error)
+	Decode(name string, value string) ([]byte, error)
+}
+
+// minKeyLen is the size of the HMAC keys, shorter ones are easy to guess
+const minKeyLen = 32
+
+// SignedCodec authenticates values with HMAC-SHA256, they are readable by
+// the client but can't be changed
+type SignedCodec struct {
+	keys [][]byte
+}
+
+// NewSignedCodec returns a codec signing with the first key and verifying
+// with any of them, keys are rotated by prepending the new one and dropping
+// the oldest once the values it signed have expired. Keys must be at least 32
+// bytes
+func NewSignedCodec(keys ...[]byte) (*SignedCodec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key", ErrInvalidKey)
+	}
+	for _, k := range keys {
+		if len(k) < minKeyLen {
+			return nil, fmt.Errorf("%w: hmac keys must be at least %d bytes", ErrInvalidKey, minKeyLen)
+		}
+	}
+	return &SignedCodec{keys: keys}, nil
+}
+
+func (c *SignedCodec) Encode(name string, p []byte) (string, error) {
+	payload := base64.RawURLEncoding.EncodeToString(p)
+	mac := sign(c.keys[0], name, payload)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+func (c *SignedCodec) Decode(name string, value string) ([]byte, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidValue
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	for _, k := range c.keys {
+		if hmac.Equal(mac, sign(k, name, payload)) {
+			p, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return nil, ErrInvalidValue
+			}
+			return p, nil
+		}
+	}
+	return nil, ErrInvalidValue
+}
+
+func sign(key []byte, name, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// EncryptedCodec encrypts values with AES-GCM, the client can neither read
+// nor change them
+type EncryptedCodec struct {
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCodec returns a codec encrypting with the first key and
+// decrypting with any of them, see NewSignedCodec for the rotation. Keys are
+// 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+func NewEncryptedCodec(keys ...[]byte) (*EncryptedCodec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key", ErrInvalidKey)
+	}
+	c := &EncryptedCodec{}
+	for _, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+func (c *EncryptedCodec) Encode(name string, p []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(p)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, p, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *EncryptedCodec) Decode(name string, value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrInvalidValue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if p, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return p, nil
+		}
+	}
+	return nil, ErrInvalidValue
+}
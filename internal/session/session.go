@@ -0,0 +1,305 @@
+// Package session keeps per-client state across requests in a cookie, either
+// holding the state itself, signed or encrypted, or the ID of a session kept
+// in a Store
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/cookie"
+	"github.com/phungducminh/httpfromtcp/internal/headers"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+)
+
+var ErrNoCodec = fmt.Errorf("session: a codec is required")
+
+const (
+	DefaultCookieName      = "session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// maxCookieSize is the size browsers guarantee to store for a cookie
+const maxCookieSize = 4096
+
+// Config configures Middleware
+type Config struct {
+	// Codec signs or encrypts the cookie, it is required
+	Codec Codec
+	// Store keeps the sessions on the server, the cookie then only holds
+	// their ID. When nil the whole session is in the cookie and must stay
+	// under 4KB
+	Store Store
+
+	// IdleTimeout expires sessions unused for that long,
+	// DefaultIdleTimeout when 0
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires sessions that long after they were created
+	// however active, DefaultAbsoluteTimeout when 0
+	AbsoluteTimeout time.Duration
+
+	// CookieName is DefaultCookieName when empty. The cookie is always
+	// HttpOnly, SameSite is Lax when unset
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   cookie.SameSite
+
+	// now is replaced by tests
+	now func() time.Time
+}
+
+// Session is the state of a client, it is safe for concurrent use. Changes
+// must be made before the response headers are written, the cookie is set
+// with them
+type Session struct {
+	mu      sync.Mutex
+	id      string
+	values  map[string]string
+	created time.Time
+	seen    time.Time
+
+	// oldID is the ID replaced by RenewID, its stored session is deleted
+	oldID     string
+	isNew     bool
+	destroyed bool
+}
+
+// record is the encoded session
+type record struct {
+	ID      string            `json:"id"`
+	Values  map[string]string `json:"v,omitempty"`
+	Created int64             `json:"c"`
+	Seen    int64             `json:"s"`
+}
+
+type contextKey struct{}
+
+// FromRequest returns the session of the request, nil when the handler isn't
+// wrapped by Middleware
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+// ID returns the random identifier of the session
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the client had no valid session
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[string]string{}
+	}
+	s.values[key] = value
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// RenewID gives the session a new ID, keeping its values. It must be called
+// when the privileges of the client change, such as on login, so an ID known
+// before can't be used to take over the session
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+}
+
+// Destroy ends the session, the client is asked to delete the cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = nil
+	s.destroyed = true
+}
+
+func newID() string {
+	p := make([]byte, 32)
+	rand.Read(p)
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+// Middleware loads the session of each request from its cookie and makes it
+// available to the handler through FromRequest. The cookie is written with the
+// response headers when the session has values, its expiry following the
+// idle and absolute timeouts
+func Middleware(cfg Config) (server.Middleware, error) {
+	if cfg.Codec == nil {
+		return nil, ErrNoCodec
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	if cfg.AbsoluteTimeout <= 0 {
+		cfg.AbsoluteTimeout = DefaultAbsoluteTimeout
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = DefaultCookieName
+	}
+	if cfg.SameSite == cookie.SameSiteDefault {
+		cfg.SameSite = cookie.SameSiteLax
+	}
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			s := cfg.load(req)
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+				cfg.save(req.Context(), s, h)
+			})
+			next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+		}
+	}, nil
+}
+
+// load returns the session of the request, a new one when it has none or it
+// has expired
+func (cfg Config) load(req *request.Request) *Session {
+	now := cfg.now()
+	if s := cfg.decode(req); s != nil {
+		if now.Sub(s.seen) < cfg.IdleTimeout && now.Sub(s.created) < cfg.AbsoluteTimeout {
+			s.seen = now
+			return s
+		}
+		if cfg.Store != nil {
+			cfg.Store.Delete(req.Context(), s.id)
+		}
+	}
+	return &Session{id: newID(), created: now, seen: now, isNew: true}
+}
+
+func (cfg Config) decode(req *request.Request) *Session {
+	c, err := req.Cookie(cfg.CookieName)
+	if err != nil {
+		return nil
+	}
+	p, err := cfg.Codec.Decode(cfg.CookieName, c.Value)
+	if err != nil {
+		return nil
+	}
+	if cfg.Store != nil {
+		data, found, err := cfg.Store.Load(req.Context(), string(p))
+		if err != nil {
+			slog.Error("failed to load session", slog.Any("err", err))
+			return nil
+		}
+		if !found {
+			return nil
+		}
+		p = data
+	}
+
+	var r record
+	if err := json.Unmarshal(p, &r); err != nil || r.ID == "" {
+		return nil
+	}
+	return &Session{
+		id:      r.ID,
+		values:  r.Values,
+		created: time.Unix(0, r.Created),
+		seen:    time.Unix(0, r.Seen),
+	}
+}
+
+// save writes the cookie of the session to the headers, new sessions without
+// values don't get one
+func (cfg Config) save(ctx context.Context, s *Session, h *headers.Headers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &cookie.Cookie{
+		Name:     cfg.CookieName,
+		Path:     cfg.Path,
+		Domain:   cfg.Domain,
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: cfg.SameSite,
+	}
+	if cfg.Store != nil && s.oldID != "" {
+		cfg.Store.Delete(ctx, s.oldID)
+	}
+	if s.destroyed {
+		if cfg.Store != nil {
+			cfg.Store.Delete(ctx, s.id)
+		}
+		if !s.isNew {
+			c.MaxAge = -1
+			h.Add("Set-Cookie", c.String())
+		}
+		return
+	}
+	if s.isNew && len(s.values) == 0 {
+		return
+	}
+
+	expires := s.seen.Add(cfg.IdleTimeout)
+	if absolute := s.created.Add(cfg.AbsoluteTimeout); absolute.Before(expires) {
+		expires = absolute
+	}
+	p, err := json.Marshal(record{
+		ID:      s.id,
+		Values:  maps.Clone(s.values),
+		Created: s.created.UnixNano(),
+		Seen:    s.seen.UnixNano(),
+	})
+	if err != nil {
+		slog.Error("failed to encode session", slog.Any("err", err))
+		return
+	}
+	if cfg.Store != nil {
+		if err := cfg.Store.Save(ctx, s.id, p, expires); err != nil {
+			slog.Error("failed to save session", slog.Any("err", err))
+			return
+		}
+		p = []byte(s.id)
+	}
+	c.Value, err = cfg.Codec.Encode(cfg.CookieName, p)
+	if err != nil {
+		slog.Error("failed to encode session", slog.Any("err", err))
+		return
+	}
+	c.Expires = expires
+	line := c.String()
+	if len(line) > maxCookieSize {
+		slog.Error("session cookie too large, use a Store", slog.Int("size", len(line)))
+		return
+	}
+	h.Add("Set-Cookie", line)
+}
@@ -0,0 +1,281 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phungducminh/httpfromtcp/internal/cookie"
+	"github.com/phungducminh/httpfromtcp/internal/httptest"
+	"github.com/phungducminh/httpfromtcp/internal/request"
+	"github.com/phungducminh/httpfromtcp/internal/response"
+	"github.com/phungducminh/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestCodecs(t *testing.T) {
+	signed, err := NewSignedCodec(key1)
+	require.NoError(t, err)
+	encrypted, err := NewEncryptedCodec(key1)
+	require.NoError(t, err)
+
+	for name, c := range map[string]Codec{"signed": signed, "encrypted": encrypted} {
+		t.Run(name, func(t *testing.T) {
+			v, err := c.Encode("session", []byte("secret data"))
+			require.NoError(t, err)
+			assert.True(t, cookie.Parse("session="+v, "") != nil, "not a cookie value: %q", v)
+			p, err := c.Decode("session", v)
+			require.NoError(t, err)
+			assert.Equal(t, "secret data", string(p))
+
+			// bound to the name of the cookie
+			_, err = c.Decode("other", v)
+			assert.ErrorIs(t, err, ErrInvalidValue)
+
+			// tampering is detected
+			tampered := []byte(v)
+			tampered[len(tampered)/3] ^= 1
+			_, err = c.Decode("session", string(tampered))
+			assert.ErrorIs(t, err, ErrInvalidValue)
+			for _, bad := range []string{"", ".", "!!", "a.b"} {
+				_, err = c.Decode("session", bad)
+				assert.ErrorIs(t, err, ErrInvalidValue, bad)
+			}
+		})
+	}
+
+	v, _ := encrypted.Encode("session", []byte("secret data"))
+	assert.NotContains(t, v, "c2VjcmV0") // base64 of "secret"
+
+	_, err = NewSignedCodec()
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = NewSignedCodec([]byte("short"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = NewEncryptedCodec(make([]byte, 20))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestCodecKeyRotation(t *testing.T) {
+	for name, newCodec := range map[string]func(keys ...[]byte) (Codec, error){
+		"signed":    func(keys ...[]byte) (Codec, error) { return NewSignedCodec(keys...) },
+		"encrypted": func(keys ...[]byte) (Codec, error) { return NewEncryptedCodec(keys...) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			old, err := newCodec(key1)
+			require.NoError(t, err)
+			v, err := old.Encode("session", []byte("data"))
+			require.NoError(t, err)
+
+			rotated, err := newCodec(key2, key1)
+			require.NoError(t, err)
+			p, err := rotated.Decode("session", v)
+			require.NoError(t, err)
+			assert.Equal(t, "data", string(p))
+
+			// values are encoded with the new key
+			v, err = rotated.Encode("session", []byte("data"))
+			require.NoError(t, err)
+			_, err = old.Decode("session", v)
+			assert.ErrorIs(t, err, ErrInvalidValue)
+
+			retired, err := newCodec(key2)
+			require.NoError(t, err)
+			_, err = retired.Decode("session", v)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// client replays the session cookie of the last response like a browser
+type client struct {
+	t       *testing.T
+	h       server.Handler
+	cookie  string
+	setLine string
+}
+
+func (c *client) do(target string) string {
+	c.t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	if c.cookie != "" {
+		req.Headers.Replace("Cookie", c.cookie)
+	}
+	res, err := httptest.Record(c.h, req).Result()
+	require.NoError(c.t, err)
+	c.setLine = ""
+	if lines := res.Headers.Values("Set-Cookie"); len(lines) > 0 {
+		require.Len(c.t, lines, 1)
+		c.setLine = lines[0]
+		sc, err := cookie.ParseSetCookie(lines[0])
+		require.NoError(c.t, err)
+		c.cookie = sc.Name + "=" + sc.Value
+		if sc.MaxAge < 0 {
+			c.cookie = ""
+		}
+	}
+	return string(res.Body)
+}
+
+// counter counts the visits of the client, /login renews the ID and /logout
+// destroys the session
+func counter(w *response.Writer, req *request.Request) {
+	s := FromRequest(req)
+	switch req.RequestLine.RequestTarget {
+	case "/login":
+		s.RenewID()
+		s.Set("user", "jane")
+	case "/logout":
+		s.Destroy()
+	case "/peek":
+	default:
+		s.Set("visits", s.Get("visits")+"+")
+	}
+	body := s.Get("user") + s.Get("visits")
+	h := response.GetDefaultHeaders(len(body))
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newClient(t *testing.T, cfg Config) (*client, *clock) {
+	t.Helper()
+	clk := &clock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cfg.now = clk.Now
+	mw, err := Middleware(cfg)
+	require.NoError(t, err)
+	return &client{t: t, h: server.Chain(counter, mw)}, clk
+}
+
+func TestMiddleware(t *testing.T) {
+	signed, _ := NewSignedCodec(key1)
+	encrypted, _ := NewEncryptedCodec(key1)
+	configs := map[string]Config{
+		"signed cookie":    {Codec: signed},
+		"encrypted cookie": {Codec: encrypted, Secure: true},
+		"store":            {Codec: signed, Store: NewMemoryStore()},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			c, clk := newClient(t, cfg)
+
+			// a session without values doesn't set a cookie
+			assert.Equal(t, "", c.do("/peek"))
+			assert.Empty(t, c.setLine)
+
+			assert.Equal(t, "+", c.do("/"))
+			assert.Contains(t, c.setLine, "session=")
+			assert.Contains(t, c.setLine, "; HttpOnly")
+			assert.Contains(t, c.setLine, "; SameSite=Lax")
+			assert.Contains(t, c.setLine, "; Expires=Tue, 01 Jan 2030 00:30:00 GMT")
+			assert.Equal(t, "++", c.do("/"))
+			assert.Equal(t, "++", c.do("/peek"))
+
+			// login renews the ID, the old cookie no longer works with a store
+			before := c.cookie
+			assert.Equal(t, "jane++", c.do("/login"))
+			if cfg.Store != nil {
+				old := &client{t: t, h: c.h, cookie: before}
+				assert.Equal(t, "", old.do("/peek"))
+			}
+
+			// the idle timeout slides with each request
+			clk.now = clk.now.Add(20 * time.Minute)
+			assert.Equal(t, "jane++", c.do("/peek"))
+			assert.Contains(t, c.setLine, "; Expires=Tue, 01 Jan 2030 00:50:00 GMT")
+			clk.now = clk.now.Add(29 * time.Minute)
+			assert.Equal(t, "jane++", c.do("/peek"))
+
+			clk.now = clk.now.Add(31 * time.Minute)
+			assert.Equal(t, "", c.do("/peek"))
+
+			assert.Equal(t, "jane", c.do("/login"))
+			assert.Equal(t, "", c.do("/logout"))
+			assert.Contains(t, c.setLine, "Max-Age=0")
+			assert.Empty(t, c.cookie)
+			if store, ok := cfg.Store.(*MemoryStore); ok {
+				assert.Zero(t, store.Len())
+			}
+		})
+	}
+}
+
+func TestMiddlewareAbsoluteTimeout(t *testing.T) {
+	signed, _ := NewSignedCodec(key1)
+	c, clk := newClient(t, Config{Codec: signed, IdleTimeout: time.Hour, AbsoluteTimeout: 2 * time.Hour})
+	assert.Equal(t, "+", c.do("/"))
+	for i := 0; i < 2; i++ {
+		clk.now = clk.now.Add(50 * time.Minute)
+		assert.Equal(t, "+", c.do("/peek"))
+	}
+	// the cookie expires with the session
+	assert.Contains(t, c.setLine, "; Expires=Tue, 01 Jan 2030 02:00:00 GMT")
+	clk.now = clk.now.Add(50 * time.Minute)
+	assert.Equal(t, "", c.do("/peek"))
+}
+
+func TestMiddlewareTampered(t *testing.T) {
+	signed, _ := NewSignedCodec(key1)
+	c, _ := newClient(t, Config{Codec: signed})
+	c.do("/")
+	c.do("/")
+	name, value, _ := strings.Cut(c.cookie, "=")
+	payload, sig, _ := strings.Cut(value, ".")
+	forged, _ := NewSignedCodec(key2)
+	v, _ := forged.Encode(name, []byte(`{"id":"x","v":{"visits":"+++++"}}`))
+	_, forgedSig, _ := strings.Cut(v, ".")
+
+	for _, cookie := range []string{name + "=" + payload + "." + forgedSig, name + "=" + strings.Split(v, ".")[0] + "." + sig, name + "=garbage"} {
+		c.cookie = cookie
+		assert.Equal(t, "+", c.do("/"), cookie)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	clk := &clock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = clk.Now
+
+	require.NoError(t, s.Save(ctx, "a", []byte("1"), clk.now.Add(time.Minute)))
+	require.NoError(t, s.Save(ctx, "b", []byte("2"), clk.now.Add(time.Hour)))
+	data, found, err := s.Load(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "1", string(data))
+
+	clk.now = clk.now.Add(time.Minute)
+	_, found, _ = s.Load(ctx, "a")
+	assert.False(t, found)
+	assert.Equal(t, 1, s.Len())
+
+	// expired sessions are swept on save
+	require.NoError(t, s.Save(ctx, "c", []byte("3"), clk.now.Add(time.Minute)))
+	clk.now = clk.now.Add(2 * time.Minute)
+	require.NoError(t, s.Save(ctx, "d", []byte("4"), clk.now.Add(time.Minute)))
+	assert.Equal(t, 2, s.Len())
+
+	require.NoError(t, s.Delete(ctx, "b"))
+	_, found, _ = s.Load(ctx, "b")
+	assert.False(t, found)
+}
+
+func TestMiddlewareConfig(t *testing.T) {
+	_, err := Middleware(Config{})
+	assert.ErrorIs(t, err, ErrNoCodec)
+}
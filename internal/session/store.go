@@ -0,0 +1,85 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps sessions on the server, the cookie only carries their ID
+type Store interface {
+	// Load returns the data of the session, found is false when it doesn't
+	// exist or has expired
+	Load(ctx context.Context, id string) (data []byte, found bool, err error)
+	// Save creates or replaces the session, it may be dropped after expires
+	Save(ctx context.Context, id string, data []byte, expires time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+// sweepInterval is how often MemoryStore drops the expired sessions
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store for a single process, sessions are lost on restart
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: map[string]memoryEntry{},
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, id string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	if !s.now().Before(e.expires) {
+		delete(s.sessions, id)
+		return nil, false, nil
+	}
+	return e.data, true, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, id string, data []byte, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		// sessions which are never loaded again would stay forever
+		for id, e := range s.sessions {
+			if !now.Before(e.expires) {
+				delete(s.sessions, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.sessions[id] = memoryEntry{data: data, expires: expires}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of sessions, expired ones included until swept
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}